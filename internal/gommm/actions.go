@@ -0,0 +1,110 @@
+package gommm
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ActionKind is what happens when a file matching an Action changes
+type ActionKind string
+
+// Actions on changed files
+const (
+	// ActionRebuild builds the binary and restarts it
+	ActionRebuild ActionKind = "rebuild"
+	// ActionRestart restarts the binary without building it
+	ActionRestart ActionKind = "restart"
+	// ActionRun runs a shell command, then rebuilds
+	ActionRun ActionKind = "run"
+	// ActionIgnore does nothing
+	ActionIgnore ActionKind = "ignore"
+)
+
+// Action maps a doublestar glob, relative to the watched directory, to an ActionKind
+type Action struct {
+	Pattern string
+	Kind    ActionKind
+	// Command run by ActionRun
+	Command string
+}
+
+// ParseAction parses pattern=action, where action is rebuild, restart, ignore or run:<command>,
+// e.g. **/*.proto=run:buf generate
+func ParseAction(s string) (Action, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return Action{}, fmt.Errorf("invalid action %q, expected pattern=action", s)
+	}
+	action := Action{Pattern: s[:i], Kind: ActionKind(s[i+1:])}
+	if strings.HasPrefix(s[i+1:], string(ActionRun)+":") {
+		action.Kind = ActionRun
+		action.Command = strings.TrimSpace(s[i+len(ActionRun)+2:])
+	}
+	switch action.Kind {
+	case ActionRebuild, ActionRestart, ActionIgnore:
+	case ActionRun:
+		if action.Command == "" {
+			return Action{}, fmt.Errorf("invalid action %q, missing command", s)
+		}
+	default:
+		return Action{}, fmt.Errorf("invalid action %q, expected rebuild, restart, ignore or run:<command>", s)
+	}
+	return action, nil
+}
+
+// Plan is what to do about a batch of changed files
+type Plan struct {
+	// Commands to run before building, in the order they were matched
+	Commands []string
+	// Rebuild the binary
+	Rebuild bool
+	// Restart the binary without building, implied by Rebuild
+	Restart bool
+}
+
+// PlanActions matches each of the changed paths below dir against actions, the first matching
+// action wins. Paths no action matches are rebuilt.
+func PlanActions(actions []Action, dir string, paths []string) Plan {
+	plan := Plan{}
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		kind, command := ActionRebuild, ""
+		rel := relPath(dir, path)
+		for _, action := range actions {
+			if MatchGlob(action.Pattern, rel) {
+				kind, command = action.Kind, action.Command
+				break
+			}
+		}
+		switch kind {
+		case ActionRebuild:
+			plan.Rebuild = true
+		case ActionRestart:
+			plan.Restart = true
+		case ActionRun:
+			plan.Rebuild = true
+			if !seen[command] {
+				seen[command] = true
+				plan.Commands = append(plan.Commands, command)
+			}
+		}
+	}
+	if plan.Rebuild {
+		plan.Restart = false
+	}
+	return plan
+}
+
+// relPath returns path relative to dir slash separated, either may be absolute
+func relPath(dir string, path string) string {
+	if filepath.IsAbs(dir) != filepath.IsAbs(path) {
+		dir, _ = filepath.Abs(dir)
+		path, _ = filepath.Abs(path)
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		rel = path
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,56 @@
+package gommm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_ParseAction(t *testing.T) {
+	action, err := gommm.ParseAction("**/*.tmpl=restart")
+	expect(t, err, nil)
+	expect(t, action, gommm.Action{Pattern: "**/*.tmpl", Kind: gommm.ActionRestart})
+
+	action, err = gommm.ParseAction("proto/**=run: buf generate")
+	expect(t, err, nil)
+	expect(t, action, gommm.Action{Pattern: "proto/**", Kind: gommm.ActionRun, Command: "buf generate"})
+
+	for _, s := range []string{"**/*.md", "=ignore", "**/*.md=skip", "**/*.proto=run:"} {
+		_, err = gommm.ParseAction(s)
+		refute(t, err, nil)
+	}
+}
+
+func Test_PlanActions(t *testing.T) {
+	var actions []gommm.Action
+	for _, s := range []string{
+		"docs/**=ignore",
+		"**/*.tmpl=restart",
+		".env=restart",
+		"**/*.proto=run:buf generate",
+		"**/*.sql=run:sqlc generate",
+	} {
+		action, err := gommm.ParseAction(s)
+		expect(t, err, nil)
+		actions = append(actions, action)
+	}
+	plan := func(paths ...string) gommm.Plan {
+		return gommm.PlanActions(actions, "app", paths)
+	}
+
+	expect(t, plan("app/docs/x.go").Rebuild, false)
+	expect(t, plan("app/docs/x.go").Restart, false)
+
+	p := plan("app/web/index.tmpl", "app/.env")
+	expect(t, p.Rebuild, false)
+	expect(t, p.Restart, true)
+
+	p = plan("app/web/index.tmpl", "app/main.go")
+	expect(t, p.Rebuild, true)
+	expect(t, p.Restart, false)
+
+	p = plan("app/api/a.proto", "app/db/q.sql", "app/api/b.proto")
+	expect(t, p.Rebuild, true)
+	expect(t, strings.Join(p.Commands, ","), "buf generate,sqlc generate")
+}
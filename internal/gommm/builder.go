@@ -1,32 +1,88 @@
 package gommm
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"log"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Builder interface {
-	Build() error
+	Build(ctx context.Context) error
 	Binary() string
 	Errors() string
+	Diagnostics() []Diagnostic
+	SetSteps(pre []Step, post []Step)
+	SetVerify(vet bool, test bool)
+	Changed(paths []string)
+	SetEnviron(env []string)
+	SetRelease(release func() error)
+	Steps() []StepResult
+	State() BuildState
+	Wait(timeout time.Duration) BuildState
+}
+
+// BuildState of the builder
+type BuildState int
+
+const (
+	// BuildIdle nothing has been built yet
+	BuildIdle BuildState = iota
+	// BuildBuilding a build is in progress
+	BuildBuilding
+	// BuildFailed the last build failed, see Errors
+	BuildFailed
+	// BuildOK the last build succeeded
+	BuildOK
+)
+
+func (s BuildState) String() string {
+	switch s {
+	case BuildIdle:
+		return "idle"
+	case BuildBuilding:
+		return "building"
+	case BuildFailed:
+		return "failed"
+	case BuildOK:
+		return "ok"
+	}
+	return fmt.Sprintf("BuildState(%d)", int(s))
 }
 
 type builder struct {
 	dir         string
 	binary      string
 	errors      string
+	diagnostics []Diagnostic
 	wd          string
 	gomodvendor bool
 	buildArgs   []string
+	environ     []string
+	pre         []Step
+	post        []Step
+	vet         bool
+	test        bool
+	changed     map[string]bool
+	results     []StepResult
+	release     func() error
 	logger      *log.Logger
+	building    sync.Mutex
+	mu          sync.Mutex
+	state       BuildState
+	done        chan struct{}
 }
 
-// NewBuilder constructor
-func NewBuilder(dir string, bin string, wd string, logger *log.Logger, gomodvendor bool, buildArgs []string) Builder {
+// NewBuilder constructor, go build and the steps run with env, gommm's own environment when nil
+func NewBuilder(dir string, bin string, wd string, env []string, logger *log.Logger, gomodvendor bool, buildArgs []string) Builder {
 	if len(bin) == 0 {
 		bin = "bin"
 	}
@@ -38,7 +94,7 @@ func NewBuilder(dir string, bin string, wd string, logger *log.Logger, gomodvend
 		}
 	}
 
-	return &builder{dir: dir, binary: bin, wd: wd, gomodvendor: gomodvendor, buildArgs: buildArgs, logger: logger}
+	return &builder{dir: dir, binary: bin, wd: wd, environ: env, gomodvendor: gomodvendor, buildArgs: buildArgs, logger: logger, done: make(chan struct{})}
 }
 
 func (b *builder) Binary() string {
@@ -46,37 +102,301 @@ func (b *builder) Binary() string {
 }
 
 func (b *builder) Errors() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	return b.errors
 }
 
-func (b *builder) Build() error {
-	if b.gomodvendor {
-		gmv := exec.Command("go", "mod", "vendor")
-		gmv.Dir = b.dir
-		b.logger.Printf("go mod vendor\n")
-		output, err := gmv.CombinedOutput()
+// Diagnostics of the last build, parsed from its output
+func (b *builder) Diagnostics() []Diagnostic {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.diagnostics
+}
+
+// SetEnviron replaces the environment of the next build
+func (b *builder) SetEnviron(env []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.environ = env
+}
+
+func (b *builder) environment() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.environ == nil {
+		return os.Environ()
+	}
+	return b.environ
+}
+
+// SetRelease sets a func called right before a new binary replaces the previous one.
+// Windows cannot replace the binary of a running app, it has to be stopped first.
+func (b *builder) SetRelease(release func() error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.release = release
+}
+
+// vendorStep runs before the pre build steps when NewBuilder is asked to run go mod vendor
+var vendorStep = Step{Name: "go mod vendor", Command: "go mod vendor", Continue: true}
+
+// SetSteps sets the steps run before go build and after it succeeded.
+// Post build steps find the new binary in GOMMM_BINARY, it replaces the
+// previous one only once they succeeded.
+func (b *builder) SetSteps(pre []Step, post []Step) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pre, b.post = pre, post
+}
+
+// SetVerify runs go vet and go test on the changed packages after the post build steps.
+// When they fail the build fails, the previous binary is kept.
+func (b *builder) SetVerify(vet bool, test bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.vet, b.test = vet, test
+}
+
+// Changed records changed files, the packages in their directories are verified
+// by the next successful build
+func (b *builder) Changed(paths []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.changed == nil {
+		b.changed = make(map[string]bool)
+	}
+	for _, path := range paths {
+		if filepath.Ext(path) != ".go" {
+			continue
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			b.changed[filepath.Dir(abs)] = true
+		}
+	}
+}
+
+// verifySteps returns the go vet and go test steps for the changed packages of the build module,
+// the built package itself when nothing changed yet
+func (b *builder) verifySteps() []Step {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.vet && !b.test {
+		return nil
+	}
+	buildDir, err := filepath.Abs(b.dir)
+	if err != nil {
+		return nil
+	}
+	module := moduleRoot(buildDir)
+	var pkgs []string
+	for dir := range b.changed {
+		if _, err := os.Stat(dir); err != nil || moduleRoot(dir) != module {
+			continue
+		}
+		rel, err := filepath.Rel(buildDir, dir)
 		if err != nil {
-			b.logger.Printf("go mod vendor err:%v\n%s\n", err, string(output))
-		} else if !gmv.ProcessState.Success() {
-			b.logger.Printf("go mod vendor no successful out:\n%s\n", string(output))
+			continue
+		}
+		pkgs = append(pkgs, "."+string(filepath.Separator)+rel)
+	}
+	if len(b.changed) == 0 {
+		pkgs = []string{"."}
+	} else if len(pkgs) == 0 {
+		return nil
+	}
+	sort.Strings(pkgs)
+	var steps []Step
+	if b.vet {
+		steps = append(steps, Step{Name: "go vet", args: append([]string{"go", "vet"}, pkgs...)})
+	}
+	if b.test {
+		steps = append(steps, Step{Name: "go test", args: append([]string{"go", "test"}, pkgs...)})
+	}
+	return steps
+}
+
+// moduleRoot returns the directory of the go.mod the absolute dir belongs to, or the empty string
+func moduleRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
 		}
+		dir = parent
 	}
-	args := append([]string{"go", "build", "-o", filepath.Join(b.wd, b.binary)}, b.buildArgs...)
+}
+
+// Steps returns the results of the steps run by the last build
+func (b *builder) Steps() []StepResult {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.results
+}
+
+func (b *builder) State() BuildState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Wait blocks until a build has completed or the timeout elapsed.
+// The returned state is idle or building if the timeout elapsed.
+func (b *builder) Wait(timeout time.Duration) BuildState {
+	b.mu.Lock()
+	state, done := b.state, b.done
+	b.mu.Unlock()
+	if state == BuildOK || state == BuildFailed {
+		return state
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+	return b.State()
+}
+
+// Build runs go build. A build cancelled through ctx, because a newer one
+// supersedes it, returns the context error and restores the state of the
+// previous build, its waiters are released with that state. When nothing
+// was built yet the waiters are left for the next build.
+func (b *builder) Build(ctx context.Context) error {
+	b.building.Lock()
+	defer b.building.Unlock()
+	b.mu.Lock()
+	previous := b.state
+	if previous == BuildOK || previous == BuildFailed {
+		b.done = make(chan struct{})
+	}
+	b.state = BuildBuilding
+	b.mu.Unlock()
+	errors, results, err := b.build(ctx)
+	if ctx.Err() != nil {
+		b.mu.Lock()
+		b.state = previous
+		if previous == BuildOK || previous == BuildFailed {
+			close(b.done)
+		}
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+	diagnostics := ParseDiagnostics(errors, b.dir)
+	b.mu.Lock()
+	b.errors = errors
+	b.results = results
+	b.diagnostics = diagnostics
+	if len(errors) > 0 {
+		b.state = BuildFailed
+	} else {
+		b.state = BuildOK
+	}
+	close(b.done)
+	b.mu.Unlock()
+	if len(errors) > 0 {
+		return fmt.Errorf("%s", errors)
+	}
+	return err
+}
+
+func (b *builder) build(ctx context.Context) (string, []StepResult, error) {
+	b.mu.Lock()
+	pre, post := b.pre, b.post
+	b.mu.Unlock()
+	if b.gomodvendor {
+		pre = append([]Step{vendorStep}, pre...)
+	}
+	environ := b.environment()
+	results, errors := b.runSteps(ctx, pre, environ, nil)
+	if ctx.Err() != nil || errors != "" {
+		return errors, results, nil
+	}
+	// build next to the binary and rename it into place once complete,
+	// the running binary is never replaced by a partial or failed build
+	binary := filepath.Join(b.wd, b.binary)
+	tmp := fmt.Sprintf("%s.%d.tmp", binary, os.Getpid())
+	defer os.Remove(tmp)
+	args := append([]string{"go", "build", "-o", tmp}, b.buildArgs...)
 	var command *exec.Cmd
 	command = exec.Command(args[0], args[1:]...)
 	command.Dir = b.dir
-	output, err := command.CombinedOutput()
+	command.Env = b.environment()
+	output, err := runContext(ctx, command)
+	if ctx.Err() != nil {
+		return "", results, ctx.Err()
+	}
 	if err != nil {
 		b.logger.Printf("build error err:%s\ncmd:%v\nout:\n%s\n", err, args, string(output))
-		b.errors = err.Error() + "\n" + string(output)
-	} else if command.ProcessState.Success() {
-		b.errors = ""
-	} else {
+		return err.Error() + "\n" + string(output), results, err
+	} else if !command.ProcessState.Success() {
 		b.logger.Printf("build status error\n  cmd:%v\n  out:\n%s\n", args, string(output))
-		b.errors = string(output)
+		return string(output), results, nil
 	}
-	if len(b.errors) > 0 {
-		return fmt.Errorf(b.errors)
+	post = append(post, b.verifySteps()...)
+	results, errors = b.runSteps(ctx, post, MergeEnv(environ, []string{"GOMMM_BINARY=" + tmp}), results)
+	if ctx.Err() != nil || errors != "" {
+		return errors, results, nil
+	}
+	b.mu.Lock()
+	b.changed = nil
+	release := b.release
+	b.mu.Unlock()
+	if release != nil {
+		if err := release(); err != nil {
+			b.logger.Printf("build release error err:%v\n", err)
+			return err.Error(), results, err
+		}
+	}
+	if err := os.Rename(tmp, binary); err != nil {
+		b.logger.Printf("build rename error err:%v\n", err)
+		return err.Error(), results, err
+	}
+	return "", results, nil
+}
+
+// runSteps runs steps in order appending their results.
+// A failing step stops the pipeline unless it is marked to continue, its output is returned as errors.
+func (b *builder) runSteps(ctx context.Context, steps []Step, env []string, results []StepResult) ([]StepResult, string) {
+	for _, step := range steps {
+		result := step.run(ctx, b.dir, env)
+		results = append(results, result)
+		if ctx.Err() != nil {
+			return results, ""
+		}
+		if result.Err == nil {
+			b.logger.Printf("%s finished in %s\n", step.Name, result.Duration.Round(time.Millisecond))
+			continue
+		}
+		if step.Continue {
+			b.logger.Printf("%s failed, continuing err:%v\n%s", step.Name, result.Err, result.Output)
+			continue
+		}
+		return results, fmt.Sprintf("%s failed err:%v\n%s", step.Name, result.Err, result.Output)
+	}
+	return results, ""
+}
+
+// runContext runs cmd returning its combined output.
+// When ctx is cancelled cmd is killed together with all processes it started.
+func runContext(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return output.Bytes(), err
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-done
+		return output.Bytes(), ctx.Err()
 	}
-	return err
 }
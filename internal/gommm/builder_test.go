@@ -1,11 +1,16 @@
 package gommm_test
 
 import (
+	"bytes"
+	"context"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/wxio/gommm/internal/gommm"
 )
@@ -22,8 +27,8 @@ func Test_Builder_Build_Success(t *testing.T) {
 		t.Fatalf("Could not get working directory: %v", err)
 	}
 
-	builder := gommm.NewBuilder(dir, bin, wd, log.New(os.Stdout, "[gommm] ", 0), false, []string{})
-	err = builder.Build()
+	builder := gommm.NewBuilder(dir, bin, wd, nil, log.New(os.Stdout, "[gommm] ", 0), false, []string{})
+	err = builder.Build(context.Background())
 	expect(t, err, nil)
 
 	file, err := os.Open(filepath.Join(wd, bin))
@@ -33,3 +38,308 @@ func Test_Builder_Build_Success(t *testing.T) {
 
 	refute(t, file, nil)
 }
+
+func Test_Builder_State(t *testing.T) {
+	dir := filepath.Join("test_fixtures", "build_success")
+	bin := "build_success"
+	if runtime.GOOS == "windows" {
+		bin += ".exe"
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Could not get working directory: %v", err)
+	}
+
+	builder := gommm.NewBuilder(dir, bin, wd, nil, log.New(os.Stdout, "[gommm] ", 0), false, []string{})
+	expect(t, builder.State(), gommm.BuildIdle)
+	expect(t, builder.Wait(10*time.Millisecond), gommm.BuildIdle)
+
+	done := make(chan gommm.BuildState)
+	go func() {
+		done <- builder.Wait(time.Minute)
+	}()
+	err = builder.Build(context.Background())
+	expect(t, err, nil)
+	expect(t, <-done, gommm.BuildOK)
+	expect(t, builder.State(), gommm.BuildOK)
+}
+
+func Test_Builder_State_Failed(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Could not get working directory: %v", err)
+	}
+
+	builder := gommm.NewBuilder(filepath.Join("test_fixtures", "not_here"), "build_failed", wd, nil, log.New(os.Stdout, "[gommm] ", 0), false, []string{})
+	err = builder.Build(context.Background())
+	refute(t, err, nil)
+	expect(t, builder.State(), gommm.BuildFailed)
+	expect(t, builder.Wait(time.Minute), gommm.BuildFailed)
+	refute(t, builder.Errors(), "")
+}
+
+func Test_Builder_Diagnostics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_build_failure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module build_failure\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {\n\tprintln(undefinedName)\n}\n"), 0644)
+
+	builder := gommm.NewBuilder(dir, "build_failure", dir, nil, log.New(os.Stdout, "[gommm] ", 0), false, []string{})
+	err = builder.Build(context.Background())
+	refute(t, err, nil)
+
+	diags := builder.Diagnostics()
+	expect(t, len(diags), 1)
+	expect(t, diags[0].Package, "build_failure")
+	expect(t, diags[0].File, filepath.Join(dir, "main.go"))
+	expect(t, diags[0].Line, 4)
+	expect(t, diags[0].Column, 10)
+	expect(t, diags[0].Message, "undefined: undefinedName")
+}
+
+func Test_Builder_Cancel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no windows fixture")
+	}
+	dir := filepath.Join("test_fixtures", "build_success")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Could not get working directory: %v", err)
+	}
+	toolexec := filepath.Join(wd, "test_fixtures", "slow_toolexec")
+
+	builder := gommm.NewBuilder(dir, "build_cancel", wd, nil, log.New(os.Stdout, "[gommm] ", 0), false, []string{"-a", "-toolexec", toolexec})
+	defer os.Remove(filepath.Join(wd, "build_cancel"))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = builder.Build(ctx)
+	expect(t, err, context.DeadlineExceeded)
+	expect(t, time.Since(start) < 5*time.Second, true)
+	// nothing was built yet, the waiters are left for the next build
+	expect(t, builder.State(), gommm.BuildIdle)
+	expect(t, builder.Wait(10*time.Millisecond), gommm.BuildIdle)
+
+}
+
+func Test_Builder_Cancel_Releases_Waiters(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no windows fixture")
+	}
+	dir := filepath.Join("test_fixtures", "build_success")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Could not get working directory: %v", err)
+	}
+	builder := gommm.NewBuilder(dir, "build_cancel", wd, nil, log.New(os.Stdout, "[gommm] ", 0), false, []string{})
+	defer os.Remove(filepath.Join(wd, "build_cancel"))
+	expect(t, builder.Build(context.Background()), nil)
+
+	builder.SetSteps([]gommm.Step{{Name: "slow", Command: "sleep 10"}}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	waited := make(chan gommm.BuildState)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		waited <- builder.Wait(5 * time.Second)
+	}()
+	expect(t, builder.Build(ctx), context.DeadlineExceeded)
+	// the waiters are released with the state of the previous build
+	select {
+	case state := <-waited:
+		expect(t, state, gommm.BuildOK)
+	case <-time.After(time.Second):
+		t.Fatal("the waiter was not released by the cancelled build")
+	}
+}
+
+func Test_Builder_Keeps_Binary_On_Failure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_build_swap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module build_swap\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+
+	builder := gommm.NewBuilder(dir, "bin", dir, nil, log.New(os.Stdout, "[gommm] ", 0), false, []string{})
+	err = builder.Build(context.Background())
+	expect(t, err, nil)
+	good, err := ioutil.ReadFile(filepath.Join(dir, builder.Binary()))
+	expect(t, err, nil)
+
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() { broken }\n"), 0644)
+	err = builder.Build(context.Background())
+	refute(t, err, nil)
+	kept, err := ioutil.ReadFile(filepath.Join(dir, builder.Binary()))
+	expect(t, err, nil)
+	expect(t, bytes.Equal(good, kept), true)
+
+	files, _ := ioutil.ReadDir(dir)
+	expect(t, len(files), 3)
+}
+
+func Test_Builder_Environ(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no sh on windows")
+	}
+	dir, err := ioutil.TempDir("", "gommm_build_environ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module build_environ\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() { tagged() }\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "tagged.go"), []byte("// +build envtag\n\npackage main\n\nfunc tagged() {}\n"), 0644)
+
+	// go build only finds tagged with the GOFLAGS of the environment
+	builder := gommm.NewBuilder(dir, "bin", dir, gommm.MergeEnv(os.Environ(), []string{"GOFLAGS=-tags=envtag", "V=1"}), log.New(os.Stdout, "[gommm] ", 0), false, []string{})
+	builder.SetSteps([]gommm.Step{{Name: "env", Command: "echo $V"}}, nil)
+	expect(t, builder.Build(context.Background()), nil)
+	expect(t, builder.Steps()[0].Output, "1\n")
+
+	builder.SetEnviron(gommm.MergeEnv(os.Environ(), []string{"V=2"}))
+	refute(t, builder.Build(context.Background()), nil)
+	expect(t, builder.Steps()[0].Output, "2\n")
+}
+
+func Test_Builder_Steps(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no sh on windows")
+	}
+	dir, err := ioutil.TempDir("", "gommm_build_steps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module build_steps\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644)
+	os.Mkdir(filepath.Join(dir, "web"), 0755)
+
+	builder := gommm.NewBuilder(dir, "bin", dir, nil, log.New(os.Stdout, "[gommm] ", 0), false, []string{})
+	builder.SetSteps([]gommm.Step{
+		{Name: "generate", Command: "printf 'package main\\n\\nconst v = \"%s\"\\n' \"$V\" > gen.go", Env: []string{"V=1"}},
+		{Name: "optional", Command: "pwd; exit 1", Dir: "web", Continue: true},
+	}, []gommm.Step{
+		{Name: "check", Command: "test -x \"$GOMMM_BINARY\" && echo checked"},
+	})
+	err = builder.Build(context.Background())
+	expect(t, err, nil)
+	gen, _ := ioutil.ReadFile(filepath.Join(dir, "gen.go"))
+	expect(t, string(gen), "package main\n\nconst v = \"1\"\n")
+	steps := builder.Steps()
+	expect(t, len(steps), 3)
+	expect(t, steps[1].Output, filepath.Join(dir, "web")+"\n")
+	refute(t, steps[1].Err, nil)
+	expect(t, steps[2].Output, "checked\n")
+	good, err := ioutil.ReadFile(filepath.Join(dir, builder.Binary()))
+	expect(t, err, nil)
+
+	// a failing post build step keeps the previous binary
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() { println() }\n"), 0644)
+	builder.SetSteps(nil, []gommm.Step{{Name: "check", Command: "echo not today; exit 1"}})
+	err = builder.Build(context.Background())
+	refute(t, err, nil)
+	expect(t, builder.State(), gommm.BuildFailed)
+	expect(t, builder.Errors(), "check failed err:exit status 1\nnot today\n")
+	kept, err := ioutil.ReadFile(filepath.Join(dir, builder.Binary()))
+	expect(t, err, nil)
+	expect(t, bytes.Equal(good, kept), true)
+
+	// as does a pre build step timing out
+	builder.SetSteps([]gommm.Step{{Name: "slow", Command: "sleep 10", Timeout: 1}}, nil)
+	start := time.Now()
+	err = builder.Build(context.Background())
+	refute(t, err, nil)
+	expect(t, time.Since(start) < 5*time.Second, true)
+	expect(t, builder.Errors(), "slow failed err:timed out after 1s\n")
+}
+
+func Test_Builder_Verify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_build_verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module build_verify\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nimport \"build_verify/calc\"\n\nfunc main() { println(calc.Add(1, 2)) }\n"), 0644)
+	os.Mkdir(filepath.Join(dir, "calc"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "calc", "calc.go"), []byte("package calc\n\nfunc Add(a, b int) int { return a + b }\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "calc", "calc_test.go"), []byte("package calc\n\nimport \"testing\"\n\nfunc TestAdd(t *testing.T) {\n\tif Add(1, 2) != 3 {\n\t\tt.Fatal(\"wrong sum\")\n\t}\n}\n"), 0644)
+
+	builder := gommm.NewBuilder(dir, "bin", dir, nil, log.New(os.Stdout, "[gommm] ", 0), false, []string{})
+	builder.SetVerify(true, true)
+	err = builder.Build(context.Background())
+	expect(t, err, nil)
+	good, err := ioutil.ReadFile(filepath.Join(dir, builder.Binary()))
+	expect(t, err, nil)
+
+	// vet findings fail the build of a compiling package
+	calc := filepath.Join(dir, "calc", "calc.go")
+	ioutil.WriteFile(calc, []byte("package calc\n\nimport \"fmt\"\n\nfunc Add(a, b int) int {\n\tfmt.Printf(\"%s\", a)\n\treturn a + b\n}\n"), 0644)
+	builder.Changed([]string{calc})
+	err = builder.Build(context.Background())
+	refute(t, err, nil)
+	diags := builder.Diagnostics()
+	expect(t, len(diags), 1)
+	expect(t, diags[0].File, calc)
+	expect(t, diags[0].Line, 6)
+
+	// changes stay pending until verified, the failing test is reported
+	ioutil.WriteFile(calc, []byte("package calc\n\nfunc Add(a, b int) int { return a - b }\n"), 0644)
+	err = builder.Build(context.Background())
+	refute(t, err, nil)
+	expect(t, strings.Contains(builder.Errors(), "wrong sum"), true)
+	kept, err := ioutil.ReadFile(filepath.Join(dir, builder.Binary()))
+	expect(t, err, nil)
+	expect(t, bytes.Equal(good, kept), true)
+
+	ioutil.WriteFile(calc, []byte("package calc\n\nfunc Add(a, b int) int { return b + a }\n"), 0644)
+	err = builder.Build(context.Background())
+	expect(t, err, nil)
+	steps := builder.Steps()
+	expect(t, len(steps), 2)
+	expect(t, steps[1].Name, "go test")
+}
+
+func Test_Builder_Rebuild_While_Running(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_build_running")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module build_running\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nimport \"time\"\n\nfunc main() {\n\tprintln(\"first\")\n\ttime.Sleep(time.Minute)\n}\n"), 0644)
+
+	logger := log.New(os.Stdout, "[gommm] ", 0)
+	builder := gommm.NewBuilder(dir, "bin", dir, nil, logger, false, []string{})
+	runner := gommm.NewRunner(filepath.Join(dir, builder.Binary()), nil, logger)
+	defer runner.Kill()
+	buff := &syncBuffer{}
+	runner.SetWriter(buff)
+	released := 0
+	builder.SetRelease(func() error {
+		released++
+		if runtime.GOOS == "windows" {
+			return runner.Kill()
+		}
+		return nil
+	})
+	expect(t, builder.Build(context.Background()), nil)
+	_, err = runner.Run()
+	expect(t, err, nil)
+	waitFor(t, buff, "first\n")
+
+	// the app keeps running while the new binary is built and replaces it
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nimport \"time\"\n\nfunc main() {\n\tprintln(\"second\")\n\ttime.Sleep(time.Minute)\n}\n"), 0644)
+	expect(t, builder.Build(context.Background()), nil)
+	expect(t, released, 2)
+	_, err = runner.Run()
+	expect(t, err, nil)
+	waitFor(t, buff, "second\n")
+}
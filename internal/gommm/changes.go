@@ -0,0 +1,160 @@
+package gommm
+
+import (
+	"path/filepath"
+)
+
+// Changes decides what a batch of changed paths below Dir does: whether it cancels the build
+// in progress, what is handed on again once it was cancelled and which processes of a procfile
+// it rebuilds or restarts
+type Changes struct {
+	// Dir is the watched directory globs are relative to
+	Dir     string
+	Actions []Action
+	// EnvFiles are the absolute paths of the env files, they only restart the apps
+	EnvFiles []string
+	// Processes of the procfile, none for run and proxy
+	Processes []ProcessChanges
+	// Graphs are the loaded package graphs, files none of them contains are watched
+	// through --include or --action
+	Graphs []*Graph
+}
+
+// ProcessChanges is what the changes of a batch are matched against for a process of the procfile
+type ProcessChanges struct {
+	// Restart globs of files only restarting the process
+	Restart []string
+	// Graph of the build of the process, any change affects the build without one
+	Graph *Graph
+}
+
+// ProcessPlan is what to do about a batch of changed files for the processes of a procfile
+type ProcessPlan struct {
+	// Plan of the paths no restart glob of a process matches
+	Plan
+	// Changed are those paths
+	Changed []string
+	// Rebuild and Restart the processes, by index
+	Rebuild []bool
+	Restart []bool
+}
+
+// IsEnvFile reports whether path is one of the env files
+func (c *Changes) IsEnvFile(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, file := range c.EnvFiles {
+		if file == abs {
+			return true
+		}
+	}
+	return false
+}
+
+// Rebuilds reports whether the paths are rebuilt, a batch that is cancels the build in progress.
+// The env files and the restart globs of the processes only restart the apps.
+func (c *Changes) Rebuilds(paths []string) bool {
+	var changed []string
+	for _, path := range paths {
+		restart := false
+		for _, p := range c.Processes {
+			restart = restart || c.matches(p.Restart, path)
+		}
+		if !restart && !c.IsEnvFile(path) {
+			changed = append(changed, path)
+		}
+	}
+	return PlanActions(c.Actions, c.Dir, changed).Rebuild
+}
+
+// Requeue returns the paths of a cancelled batch to hand on again ahead of the queued ones,
+// without the env files, which were evaluated already
+func (c *Changes) Requeue(cancelled []string, queued []string) []string {
+	var again []string
+	for _, path := range cancelled {
+		if !c.IsEnvFile(path) {
+			again = append(again, path)
+		}
+	}
+	return AppendNew(again, queued...)
+}
+
+// PlanProcesses plans the paths for the processes. Files matching the restart globs of
+// a process only restart it, a rebuild only rebuilds the processes whose builds it affects.
+func (c *Changes) PlanProcesses(paths []string) ProcessPlan {
+	plan := ProcessPlan{
+		Rebuild: make([]bool, len(c.Processes)),
+		Restart: make([]bool, len(c.Processes)),
+	}
+	for _, path := range paths {
+		matched := false
+		for i, p := range c.Processes {
+			if c.matches(p.Restart, path) {
+				plan.Restart[i], matched = true, true
+			}
+		}
+		if !matched {
+			plan.Changed = append(plan.Changed, path)
+		}
+	}
+	plan.Plan = PlanActions(c.Actions, c.Dir, plan.Changed)
+	for i, p := range c.Processes {
+		plan.Rebuild[i] = plan.Plan.Rebuild && c.affects(p.Graph, plan.Changed)
+		plan.Restart[i] = !plan.Rebuild[i] && (plan.Plan.Restart || plan.Restart[i])
+	}
+	return plan
+}
+
+// matches reports whether path matches one of globs
+func (c *Changes) matches(globs []string, path string) bool {
+	rel, err := filepath.Rel(c.Dir, path)
+	if err != nil {
+		return false
+	}
+	for _, glob := range globs {
+		if MatchGlob(glob, filepath.ToSlash(rel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// affects reports whether changed paths affect the build of graph. Any change does without a graph,
+// as do files watched through --include or --action, which are not part of any graph.
+func (c *Changes) affects(graph *Graph, paths []string) bool {
+	for _, path := range paths {
+		if graph == nil || graph.Contains(path) || !c.inGraphs(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// inGraphs reports whether one of the package graphs contains path
+func (c *Changes) inGraphs(path string) bool {
+	for _, graph := range c.Graphs {
+		if graph.Contains(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// AppendNew appends the paths not in list yet
+func AppendNew(list []string, paths ...string) []string {
+	for _, path := range paths {
+		found := false
+		for _, p := range list {
+			if p == path {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, path)
+		}
+	}
+	return list
+}
@@ -0,0 +1,100 @@
+package gommm_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_Changes_Rebuilds(t *testing.T) {
+	dir, _ := filepath.Abs("app")
+	env := filepath.Join(dir, ".env")
+	action, err := gommm.ParseAction("docs/**=ignore")
+	expect(t, err, nil)
+	changes := &gommm.Changes{
+		Dir:       dir,
+		Actions:   []gommm.Action{action},
+		EnvFiles:  []string{env},
+		Processes: []gommm.ProcessChanges{{Restart: []string{"web/**"}}, {}},
+	}
+	file := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	expect(t, changes.IsEnvFile(env), true)
+	expect(t, changes.IsEnvFile(file("main.go")), false)
+	// only a batch rebuilding cancels the build in progress
+	expect(t, changes.Rebuilds([]string{file("main.go")}), true)
+	expect(t, changes.Rebuilds([]string{env, file("web/index.tmpl"), file("docs/x.go")}), false)
+	expect(t, changes.Rebuilds([]string{env, file("main.go")}), true)
+
+	// the env files of a cancelled batch were evaluated, the rest is handed on ahead of the queued paths
+	again := changes.Requeue([]string{file("a.go"), env, file("b.go")}, []string{file("b.go"), file("c.go")})
+	expect(t, strings.Join(again, " "), strings.Join([]string{file("a.go"), file("b.go"), file("c.go")}, " "))
+}
+
+func Test_Changes_PlanProcesses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+	writeFiles(t, dir, map[string]string{
+		"go.mod":              "module app\n",
+		"lib/lib.go":          "package lib\n\nfunc F() {}\n",
+		"cmd/api/main.go":     "package main\n\nimport \"app/lib\"\n\nfunc main() { lib.F() }\n",
+		"cmd/worker/main.go":  "package main\n\nfunc main() {}\n",
+		"web/index.tmpl":      "index\n",
+		"config/worker.yaml":  "queue: high\n",
+		"cmd/worker/job.tmpl": "job\n",
+	})
+	api, worker := gommm.NewGraph(filepath.Join(dir, "cmd", "api"), "."), gommm.NewGraph(filepath.Join(dir, "cmd", "worker"), ".")
+	expect(t, api.Load(context.Background()), nil)
+	expect(t, worker.Load(context.Background()), nil)
+	action, err := gommm.ParseAction("**/*.tmpl=restart")
+	expect(t, err, nil)
+	changes := &gommm.Changes{
+		Dir:       dir,
+		Actions:   []gommm.Action{action},
+		Processes: []gommm.ProcessChanges{{Restart: []string{"web/**"}, Graph: api}, {Graph: worker}, {}},
+		Graphs:    []*gommm.Graph{api, worker},
+	}
+	plan := func(names ...string) string {
+		var paths []string
+		for _, name := range names {
+			paths = append(paths, filepath.Join(dir, name))
+		}
+		p := changes.PlanProcesses(paths)
+		var s []string
+		for i := range p.Rebuild {
+			switch {
+			case p.Rebuild[i]:
+				s = append(s, "rebuild")
+			case p.Restart[i]:
+				s = append(s, "restart")
+			default:
+				s = append(s, "-")
+			}
+		}
+		return strings.Join(s, " ")
+	}
+
+	// a rebuild only rebuilds the processes whose builds it affects, any without a graph
+	expect(t, plan("cmd/worker/main.go"), "- rebuild rebuild")
+	expect(t, plan("lib/lib.go"), "rebuild - rebuild")
+	// files of no graph are watched through --include or --action and rebuild all
+	expect(t, plan("config/worker.yaml"), "rebuild rebuild rebuild")
+	// the restart globs of a process only restart it, the restart actions all of them
+	expect(t, plan("web/index.tmpl"), "restart - -")
+	expect(t, plan("cmd/worker/job.tmpl"), "restart restart restart")
+	expect(t, plan("web/index.tmpl", "cmd/worker/main.go"), "restart rebuild rebuild")
+
+	p := changes.PlanProcesses([]string{filepath.Join(dir, "web/index.tmpl"), filepath.Join(dir, "lib/lib.go")})
+	expect(t, strings.Join(p.Changed, " "), filepath.Join(dir, "lib/lib.go"))
+}
@@ -12,6 +12,10 @@ type Config struct {
 	ProxyTo  string `json:"proxy_to"`
 	KeyFile  string `json:"key_file"`
 	CertFile string `json:"cert_file"`
+	// Timeout in seconds requests are held while a build is in progress (default 30)
+	Timeout int `json:"timeout"`
+	// LiveReload injects a script into html pages reloading them after a rebuild
+	LiveReload bool `json:"live_reload"`
 }
 
 func LoadConfig(path string) (*Config, error) {
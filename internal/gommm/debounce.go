@@ -0,0 +1,51 @@
+package gommm
+
+import (
+	"time"
+)
+
+// BatchCallback is called with the files changed during one burst of changes
+type BatchCallback func(paths []string)
+
+// Debounce collects changed paths until none arrive for the quiet period and
+// then hands them to cb as a single batch, each path reported once.
+// Batches are delivered one at a time, changes arriving while cb runs are
+// collected into the next batch.
+func Debounce(quiet time.Duration, cb BatchCallback) WatchCallback {
+	changes := make(chan string)
+	batches := make(chan []string)
+	go func() {
+		var (
+			pending []string
+			seen    = make(map[string]bool)
+			quietC  <-chan time.Time
+			out     chan []string
+		)
+		for {
+			select {
+			case path := <-changes:
+				if !seen[path] {
+					seen[path] = true
+					pending = append(pending, path)
+				}
+				quietC = time.After(quiet)
+				out = nil
+			case <-quietC:
+				quietC = nil
+				out = batches
+			case out <- pending:
+				pending = nil
+				seen = make(map[string]bool)
+				out = nil
+			}
+		}
+	}()
+	go func() {
+		for batch := range batches {
+			cb(batch)
+		}
+	}()
+	return func(path string) {
+		changes <- path
+	}
+}
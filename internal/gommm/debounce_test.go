@@ -0,0 +1,58 @@
+package gommm_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_Debounce_Coalesces(t *testing.T) {
+	batches := make(chan []string, 4)
+	cb := gommm.Debounce(100*time.Millisecond, func(paths []string) {
+		batches <- paths
+	})
+	cb("a.go")
+	cb("b.go")
+	cb("a.go")
+	cb("c.go")
+
+	select {
+	case batch := <-batches:
+		expect(t, strings.Join(batch, ","), "a.go,b.go,c.go")
+	case <-time.After(time.Second):
+		t.Fatal("No batch delivered")
+	}
+	select {
+	case batch := <-batches:
+		t.Fatalf("Unexpected batch %v", batch)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func Test_Debounce_CollectsWhileBusy(t *testing.T) {
+	batches := make(chan []string, 4)
+	release := make(chan struct{})
+	cb := gommm.Debounce(50*time.Millisecond, func(paths []string) {
+		batches <- paths
+		<-release
+	})
+	cb("a.go")
+	first := <-batches
+	expect(t, strings.Join(first, ","), "a.go")
+
+	// the callback is still busy, these must end up in one batch
+	cb("b.go")
+	time.Sleep(100 * time.Millisecond)
+	cb("c.go")
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	select {
+	case batch := <-batches:
+		expect(t, strings.Join(batch, ","), "b.go,c.go")
+	case <-time.After(time.Second):
+		t.Fatal("No batch delivered")
+	}
+}
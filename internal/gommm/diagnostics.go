@@ -0,0 +1,79 @@
+package gommm
+
+import (
+	"bufio"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Severity of a diagnostic
+type Severity string
+
+const (
+	// SeverityError prevents the build from succeeding
+	SeverityError Severity = "error"
+	// SeverityWarning is reported but does not fail the build
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is one message of the go tool located in a source file
+type Diagnostic struct {
+	Package  string   `json:"package,omitempty"`
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column,omitempty"`
+	Message  string   `json:"message"`
+	Severity Severity `json:"severity"`
+}
+
+func (d Diagnostic) String() string {
+	pos := fmt.Sprintf("%s:%d", d.File, d.Line)
+	if d.Column > 0 {
+		pos += fmt.Sprintf(":%d", d.Column)
+	}
+	if d.Severity == SeverityWarning {
+		return pos + ": warning: " + d.Message
+	}
+	return pos + ": " + d.Message
+}
+
+// indented lines, like the failures of go test relative to their package, are no diagnostics
+var (
+	diagnosticLine    = regexp.MustCompile(`^(\S.*?\.[a-zA-Z0-9]+):(\d+)(?::(\d+))?: (.*)$`)
+	diagnosticPackage = regexp.MustCompile(`^# (\S+)`)
+)
+
+// ParseDiagnostics extracts the located messages from go build output.
+// Relative file names are resolved against dir, the directory the go tool ran in.
+func ParseDiagnostics(output string, dir string) []Diagnostic {
+	var (
+		diags []Diagnostic
+		pkg   string
+	)
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := diagnosticPackage.FindStringSubmatch(line); m != nil {
+			pkg = m[1]
+		} else if m := diagnosticLine.FindStringSubmatch(line); m != nil {
+			d := Diagnostic{Package: pkg, File: m[1], Message: m[4], Severity: SeverityError}
+			if !filepath.IsAbs(d.File) {
+				d.File = filepath.Join(dir, d.File)
+			}
+			d.Line, _ = strconv.Atoi(m[2])
+			d.Column, _ = strconv.Atoi(m[3])
+			if strings.HasPrefix(d.Message, "warning: ") {
+				d.Severity = SeverityWarning
+				d.Message = strings.TrimPrefix(d.Message, "warning: ")
+			}
+			diags = append(diags, d)
+		} else if len(diags) > 0 && strings.HasPrefix(line, "\t") {
+			// continuation of the previous message, e.g. have/want of a type error
+			diags[len(diags)-1].Message += "\n" + strings.TrimSpace(line)
+		}
+	}
+	return diags
+}
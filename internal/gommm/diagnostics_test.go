@@ -0,0 +1,47 @@
+package gommm_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_ParseDiagnostics(t *testing.T) {
+	output := `exit status 2
+# github.com/wxio/app/cmd/api
+./main.go:12:2: undefined: foo
+cmd/api/handler.go:30:9: cannot use x (variable of type int) as string value in return statement
+	have (int)
+	want (string)
+# github.com/wxio/app/internal/c
+internal/c/c.go:7: warning: unused variable 'y'
+`
+	diags := gommm.ParseDiagnostics(output, "src")
+	expect(t, len(diags), 3)
+
+	expect(t, diags[0].Package, "github.com/wxio/app/cmd/api")
+	expect(t, diags[0].File, filepath.Join("src", "main.go"))
+	expect(t, diags[0].Line, 12)
+	expect(t, diags[0].Column, 2)
+	expect(t, diags[0].Message, "undefined: foo")
+	expect(t, diags[0].Severity, gommm.SeverityError)
+
+	expect(t, diags[1].File, filepath.Join("src", "cmd", "api", "handler.go"))
+	expect(t, diags[1].Message, "cannot use x (variable of type int) as string value in return statement\nhave (int)\nwant (string)")
+
+	expect(t, diags[2].Package, "github.com/wxio/app/internal/c")
+	expect(t, diags[2].Line, 7)
+	expect(t, diags[2].Column, 0)
+	expect(t, diags[2].Severity, gommm.SeverityWarning)
+	expect(t, diags[2].Message, "unused variable 'y'")
+	expect(t, diags[2].String(), filepath.Join("src", "internal", "c", "c.go")+":7: warning: unused variable 'y'")
+}
+
+func Test_ParseDiagnostics_Unlocated(t *testing.T) {
+	diags := gommm.ParseDiagnostics("exit status 1\ngo: cannot find main module\n", ".")
+	expect(t, len(diags), 0)
+	// go test failures are relative to their package
+	diags = gommm.ParseDiagnostics("--- FAIL: TestAdd (0.00s)\n    calc_test.go:7: wrong sum\nFAIL\n", ".")
+	expect(t, len(diags), 0)
+}
@@ -0,0 +1,220 @@
+package gommm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// EnvVar is a variable read from a dotenv file
+type EnvVar struct {
+	Key string
+	// Value with quotes and escapes resolved, before any expansion
+	Value string
+	// Expand is false for single quoted values, which are used as written
+	Expand bool
+	File   string
+	Line   int
+}
+
+var dotenvKey = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.]*$`)
+
+// ExpandDotenv expands $VAR and ${VAR} in the value of v with env and then executes it as a
+// template of .Env, e.g. {{ .Env.HOME }}. Values not to be expanded are returned as they are.
+// On a template error the value is returned with the variables expanded.
+func ExpandDotenv(v EnvVar, env map[string]string) (string, error) {
+	if !v.Expand {
+		return v.Value, nil
+	}
+	val := os.Expand(v.Value, func(key string) string { return env[key] })
+	tpl, err := template.New("").Parse(val)
+	if err != nil {
+		return val, err
+	}
+	buf := bytes.Buffer{}
+	if err := tpl.Execute(&buf, struct{ Env map[string]string }{env}); err != nil {
+		return val, err
+	}
+	return buf.String(), nil
+}
+
+// LoadDotenv reads the variables of the dotenv file at path, see ParseDotenv
+func LoadDotenv(path string) ([]EnvVar, error) {
+	fr, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fr.Close()
+	return ParseDotenv(fr, path)
+}
+
+// ParseDotenv reads variables from a dotenv file named file, one KEY=value per line:
+//
+//   - blank lines and lines starting with # are skipped, KEY may be preceded by export
+//   - an unquoted value is trimmed and ends at a # preceded by a space, so URL=http://x/#frag is kept.
+//     \# is a # that never starts a comment and a \ at the end of the line continues the value on the next one
+//   - a double quoted value may span lines and resolves the escapes \n \r \t \" and \\
+//   - a single quoted value may span lines, is used as written and not expanded
+//   - only a comment may follow a quoted value
+//
+// Errors are reported with file and line number.
+func ParseDotenv(r io.Reader, file string) ([]EnvVar, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &dotenvParser{src: strings.ReplaceAll(string(b), "\r\n", "\n"), file: file, line: 1}
+	var vars []EnvVar
+	for {
+		v, ok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return vars, nil
+		}
+		vars = append(vars, v)
+	}
+}
+
+type dotenvParser struct {
+	src  string
+	pos  int
+	file string
+	line int
+}
+
+func (p *dotenvParser) errorf(line int, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.file, line, fmt.Sprintf(format, args...))
+}
+
+// readLine returns the rest of the current line and moves to the next one
+func (p *dotenvParser) readLine() string {
+	rest := p.src[p.pos:]
+	i := strings.IndexByte(rest, '\n')
+	if i < 0 {
+		p.pos = len(p.src)
+		return rest
+	}
+	p.pos += i + 1
+	p.line++
+	return rest[:i]
+}
+
+// next returns the next variable, ok is false at the end of the file
+func (p *dotenvParser) next() (EnvVar, bool, error) {
+	for p.pos < len(p.src) {
+		line := p.line
+		start := p.pos
+		text := strings.TrimSpace(p.readLine())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		if strings.HasPrefix(text, "export ") || strings.HasPrefix(text, "export\t") {
+			text = strings.TrimSpace(text[len("export"):])
+		}
+		i := strings.Index(text, "=")
+		if i < 0 {
+			return EnvVar{}, false, p.errorf(line, "expected KEY=value")
+		}
+		key := strings.TrimSpace(text[:i])
+		if !dotenvKey.MatchString(key) {
+			return EnvVar{}, false, p.errorf(line, "invalid variable name %q", key)
+		}
+		v := EnvVar{Key: key, Expand: true, File: p.file, Line: line}
+		// continue after the = on the raw line, quoted values may span lines
+		p.pos, p.line = start+strings.Index(p.src[start:], "=")+1, line
+		for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+			p.pos++
+		}
+		var err error
+		switch {
+		case p.pos < len(p.src) && p.src[p.pos] == '"':
+			v.Value, err = p.quoted('"')
+		case p.pos < len(p.src) && p.src[p.pos] == '\'':
+			v.Value, err = p.quoted('\'')
+			v.Expand = false
+		default:
+			v.Value = p.unquoted()
+		}
+		if err != nil {
+			return EnvVar{}, false, err
+		}
+		return v, true, nil
+	}
+	return EnvVar{}, false, nil
+}
+
+// quoted reads a value from the opening quote to the closing one
+func (p *dotenvParser) quoted(quote byte) (string, error) {
+	line := p.line
+	var val strings.Builder
+	for p.pos++; p.pos < len(p.src); p.pos++ {
+		c := p.src[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			end := p.line
+			rest := strings.TrimSpace(p.readLine())
+			if rest != "" && rest[0] != '#' {
+				return "", p.errorf(end, "unexpected %q after the closing quote", rest)
+			}
+			return val.String(), nil
+		case c == '\n':
+			p.line++
+			val.WriteByte(c)
+		case c == '\\' && quote == '"' && p.pos+1 < len(p.src):
+			p.pos++
+			switch e := p.src[p.pos]; e {
+			case 'n':
+				val.WriteByte('\n')
+			case 'r':
+				val.WriteByte('\r')
+			case 't':
+				val.WriteByte('\t')
+			case '"', '\\':
+				val.WriteByte(e)
+			default:
+				p.pos--
+				val.WriteByte(c)
+			}
+		default:
+			val.WriteByte(c)
+		}
+	}
+	return "", p.errorf(line, "unterminated %c quoted value", quote)
+}
+
+// unquoted reads a value up to a comment or the end of the line, continuing on the next line
+// after a trailing \
+func (p *dotenvParser) unquoted() string {
+	var val strings.Builder
+	for {
+		text := p.readLine()
+		cont := false
+		for i := 0; i < len(text); i++ {
+			c := text[i]
+			if c == '\\' && i+1 < len(text) && text[i+1] == '#' {
+				val.WriteByte('#')
+				i++
+				continue
+			}
+			if c == '\\' && strings.TrimSpace(text[i+1:]) == "" && p.pos < len(p.src) {
+				cont = true
+				break
+			}
+			if c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t') {
+				break
+			}
+			val.WriteByte(c)
+		}
+		if !cont {
+			return strings.TrimSpace(val.String())
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package gommm_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_ParseDotenv(t *testing.T) {
+	src := strings.Join([]string{
+		"# comment",
+		"",
+		"PLAIN=value",
+		"export EXPORTED = spaced value  # comment",
+		"URL=http://x/#frag",
+		"ESCAPED=a\\#b #c",
+		"EMPTY=",
+		`DOUBLE="a \"b\"\n\tc # not a comment" # comment`,
+		"SINGLE='$HOME \\n'",
+		`PEM="-----BEGIN KEY-----`,
+		"abc",
+		`-----END KEY-----"`,
+		"CONTINUED=one \\",
+		"two",
+		"AFTER=$PLAIN",
+	}, "\n")
+	vars, err := gommm.ParseDotenv(strings.NewReader(src), ".env")
+	expect(t, err, nil)
+	var got []string
+	for _, v := range vars {
+		got = append(got, fmt.Sprintf("%d %s=%q %v", v.Line, v.Key, v.Value, v.Expand))
+	}
+	expect(t, strings.Join(got, "\n"), strings.Join([]string{
+		`3 PLAIN="value" true`,
+		`4 EXPORTED="spaced value" true`,
+		`5 URL="http://x/#frag" true`,
+		`6 ESCAPED="a#b" true`,
+		`7 EMPTY="" true`,
+		`8 DOUBLE="a \"b\"\n\tc # not a comment" true`,
+		`9 SINGLE="$HOME \\n" false`,
+		`10 PEM="-----BEGIN KEY-----\nabc\n-----END KEY-----" true`,
+		`13 CONTINUED="one two" true`,
+		`15 AFTER="$PLAIN" true`,
+	}, "\n"))
+	expect(t, vars[0].File, ".env")
+}
+
+func Test_ParseDotenv_Errors(t *testing.T) {
+	for src, msg := range map[string]string{
+		"A=1\nnot a variable\n": ".env:2: expected KEY=value",
+		"A=1\n1A=2\n":           ".env:2: invalid variable name \"1A\"",
+		"A=1\nB=\"open\nC=3\n":  ".env:2: unterminated \" quoted value",
+		"A='open\n":             ".env:1: unterminated ' quoted value",
+		"A=\"a\nb\" trailing\n": ".env:2: unexpected \"trailing\" after the closing quote",
+		"export A B=1\n":        ".env:1: invalid variable name \"A B\"",
+	} {
+		_, err := gommm.ParseDotenv(strings.NewReader(src), ".env")
+		refute(t, err, nil)
+		expect(t, err.Error(), msg)
+	}
+}
@@ -0,0 +1,105 @@
+package gommm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Env is an evaluated environment variable
+type Env struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	// Sources are the definitions of the variable in env files, the last one wins.
+	// There are none for a variable inherited from gommm's own environment.
+	Sources []EnvSource `json:"sources,omitempty"`
+}
+
+// EnvSource is a definition of a variable in an env file
+type EnvSource struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	// Form is the value as written, before expansion
+	Form  string `json:"form"`
+	Value string `json:"value"`
+}
+
+// Environment output formats
+const (
+	// EnvDotenv writes KEY=value lines, quoted where needed for ParseDotenv, which cannot hold
+	// values with both a single quote and $ or {{ without expanding them
+	EnvDotenv = "dotenv"
+	// EnvShell writes export lines for a shell to eval
+	EnvShell = "shell"
+	// EnvJSON writes an array of Env including their sources
+	EnvJSON = "json"
+	// EnvDocker writes a docker --env-file, which cannot hold multi-line values
+	EnvDocker = "docker"
+)
+
+var envPlain = regexp.MustCompile(`^[a-zA-Z0-9_./:@%+,=-]*$`)
+
+// WriteEnv writes vars to w in format
+func WriteEnv(w io.Writer, format string, vars []Env) error {
+	if format == EnvJSON {
+		if vars == nil {
+			vars = []Env{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(vars)
+	}
+	var line func(Env) (string, error)
+	switch format {
+	case EnvDotenv, "":
+		line = func(v Env) (string, error) {
+			if strings.Contains(v.Value, "'") && (strings.Contains(v.Value, "$") || strings.Contains(v.Value, "{{")) {
+				return "", fmt.Errorf("%s has a value with a single quote and $ or {{, dotenv files cannot hold it unexpanded", v.Key)
+			}
+			return v.Key + "=" + dotenvQuote(v.Value), nil
+		}
+	case EnvShell:
+		line = func(v Env) (string, error) {
+			return "export " + v.Key + "=" + shellQuote(v.Value), nil
+		}
+	case EnvDocker:
+		line = func(v Env) (string, error) {
+			if strings.ContainsAny(v.Value, "\r\n") {
+				return "", fmt.Errorf("%s has a multi-line value, docker env files cannot hold it", v.Key)
+			}
+			return v.Key + "=" + v.Value, nil
+		}
+	default:
+		return fmt.Errorf("invalid format %q, expected dotenv, shell, json or docker", format)
+	}
+	for _, v := range vars {
+		l, err := line(v)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(w, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dotenvQuote quotes s unless it is plain, single quotes keep it from being expanded.
+// Double quotes hold a single quote, s has neither $ nor {{ then.
+func dotenvQuote(s string) string {
+	if envPlain.MatchString(s) {
+		return s
+	}
+	if !strings.Contains(s, "'") {
+		return "'" + s + "'"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// shellQuote single quotes s for a posix shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,73 @@
+package gommm_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_WriteEnv(t *testing.T) {
+	vars := []gommm.Env{
+		{Key: "PLAIN", Value: "http://x/y"},
+		{Key: "SPACED", Value: "a $b"},
+		{Key: "QUOTE", Value: "it's\n\"pem\""},
+		{Key: "DOLLAR", Value: "it's $HOME {{ .Env.HOME }}"},
+		{Key: "FROM", Value: "1-2", Sources: []gommm.EnvSource{{File: ".env", Line: 3, Form: "${A}-2", Value: "1-2"}}},
+	}
+	for format, want := range map[string]string{
+		gommm.EnvShell: "export PLAIN='http://x/y'\nexport SPACED='a $b'\nexport QUOTE='it'\\''s\n\"pem\"'\nexport DOLLAR='it'\\''s $HOME {{ .Env.HOME }}'\nexport FROM='1-2'\n",
+	} {
+		buff := &bytes.Buffer{}
+		expect(t, gommm.WriteEnv(buff, format, vars), nil)
+		expect(t, buff.String(), want)
+	}
+
+	dotenv := []gommm.Env{vars[0], vars[1], vars[2], {Key: "TMPL", Value: "$HOME {{ .Env.HOME }}"}, vars[4]}
+	buff := &bytes.Buffer{}
+	expect(t, gommm.WriteEnv(buff, gommm.EnvDotenv, dotenv), nil)
+	expect(t, buff.String(), "PLAIN=http://x/y\nSPACED='a $b'\nQUOTE=\"it's\\n\\\"pem\\\"\"\nTMPL='$HOME {{ .Env.HOME }}'\nFROM=1-2\n")
+
+	// dotenv output reads back as it was, without expanding it
+	parsed, err := gommm.ParseDotenv(buff, "out")
+	expect(t, err, nil)
+	expect(t, len(parsed), len(dotenv))
+	for i, v := range parsed {
+		val, err := gommm.ExpandDotenv(v, map[string]string{"HOME": "/home/x", "b": "B"})
+		expect(t, err, nil)
+		expect(t, val, dotenv[i].Value)
+	}
+
+	// other loaders would expand a double quoted $ or {{
+	err = gommm.WriteEnv(buff, gommm.EnvDotenv, vars)
+	refute(t, err, nil)
+	expect(t, strings.Contains(err.Error(), "DOLLAR has a value with a single quote"), true)
+
+	buff.Reset()
+	expect(t, gommm.WriteEnv(buff, gommm.EnvJSON, vars[4:]), nil)
+	expect(t, buff.String(), `[
+  {
+    "key": "FROM",
+    "value": "1-2",
+    "sources": [
+      {
+        "file": ".env",
+        "line": 3,
+        "form": "${A}-2",
+        "value": "1-2"
+      }
+    ]
+  }
+]
+`)
+
+	buff.Reset()
+	expect(t, gommm.WriteEnv(buff, gommm.EnvDocker, vars[:2]), nil)
+	expect(t, buff.String(), "PLAIN=http://x/y\nSPACED=a $b\n")
+	err = gommm.WriteEnv(buff, gommm.EnvDocker, vars)
+	refute(t, err, nil)
+	expect(t, strings.Contains(err.Error(), "QUOTE has a multi-line value"), true)
+
+	refute(t, gommm.WriteEnv(buff, "yaml", vars), nil)
+}
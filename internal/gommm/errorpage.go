@@ -0,0 +1,108 @@
+package gommm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// located is a diagnostic with the source around it
+type located struct {
+	Diagnostic
+	Snippet []sourceLine
+}
+
+type sourceLine struct {
+	Number int
+	Text   string
+	Error  bool
+}
+
+// readSnippet returns the lines of file around line
+func readSnippet(file string, line int, context int) []sourceLine {
+	fr, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer fr.Close()
+	var lines []sourceLine
+	scanner := bufio.NewScanner(fr)
+	for n := 1; scanner.Scan() && n <= line+context; n++ {
+		if n >= line-context {
+			lines = append(lines, sourceLine{Number: n, Text: scanner.Text(), Error: n == line})
+		}
+	}
+	return lines
+}
+
+// writeBuildErrors responds with the build errors in the format the client accepts,
+// script is injected into the html page when set
+func writeBuildErrors(res http.ResponseWriter, req *http.Request, output string, diags []Diagnostic, script []byte) {
+	accept := req.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "text/html"):
+		errs := make([]located, 0, len(diags))
+		for _, d := range diags {
+			errs = append(errs, located{d, readSnippet(d.File, d.Line, 3)})
+		}
+		page := bytes.Buffer{}
+		errorPage.Execute(&page, struct {
+			Errors []located
+			Output string
+		}{errs, output})
+		res.Header().Set("Content-Type", "text/html; charset=utf-8")
+		res.WriteHeader(http.StatusInternalServerError)
+		res.Write(injectScript(page.Bytes(), script))
+	case strings.Contains(accept, "application/json"):
+		if diags == nil {
+			diags = []Diagnostic{}
+		}
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(res).Encode(struct {
+			Errors []Diagnostic `json:"errors"`
+			Output string       `json:"output"`
+		}{diags, output})
+	default:
+		res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		res.WriteHeader(http.StatusInternalServerError)
+		res.Write([]byte(output))
+	}
+}
+
+var errorPage = template.Must(template.New("errors").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>Build failed</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { color: #c00; }
+.error, .warning { margin-bottom: 2em; }
+.warning .location { color: #a60; }
+.location { font-family: monospace; font-weight: bold; }
+.message { white-space: pre-wrap; margin: 0.5em 0; }
+table.source { border-collapse: collapse; font-family: monospace; background: #f6f6f6; width: 100%; }
+table.source td { padding: 0 0.5em; white-space: pre; }
+table.source td.number { color: #999; text-align: right; width: 1%; }
+table.source tr.current { background: #fdd; }
+pre.output { background: #f6f6f6; padding: 1em; }
+</style>
+</head>
+<body>
+<h1>Build failed</h1>
+{{range .Errors}}<div class="{{.Severity}}">
+<div class="location">{{.File}}:{{.Line}}{{if .Column}}:{{.Column}}{{end}}{{if .Package}} ({{.Package}}){{end}}</div>
+<div class="message">{{.Message}}</div>
+{{if .Snippet}}<table class="source">
+{{range .Snippet}}<tr{{if .Error}} class="current"{{end}}><td class="number">{{.Number}}</td><td>{{.Text}}</td></tr>
+{{end}}</table>{{end}}
+</div>
+{{end}}<h2>Output</h2>
+<pre class="output">{{.Output}}</pre>
+</body>
+</html>
+`))
@@ -0,0 +1,41 @@
+package gommm
+
+import (
+	"path"
+	"strings"
+)
+
+// MatchGlob reports whether the slash separated name matches the doublestar pattern.
+// A "**" segment matches zero or more path segments, all other segments follow path.Match.
+func MatchGlob(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// collapse consecutive ** and try every split of the remaining name
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		seg := strings.Replace(pattern[0], "**", "*", -1)
+		if ok, err := path.Match(seg, name[0]); !ok || err != nil {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,36 @@
+package gommm_test
+
+import (
+	"testing"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_MatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "cmd/api/main.go", true},
+		{"**/*.go", "cmd/api/main.go.orig", false},
+		{"*.go", "cmd/main.go", false},
+		{"web/dist", "web/dist", true},
+		{"web/dist", "web/dist/app.js", false},
+		{"web/dist/**", "web/dist", true},
+		{"web/dist/**", "web/dist/js/app.js", true},
+		{"**/node_modules", "node_modules", true},
+		{"**/node_modules", "web/node_modules", true},
+		{"**/node_modules", "web/node_modules_x", false},
+		{"web/**/*.tmpl", "web/a/b/c.tmpl", true},
+		{"web/**/*.tmpl", "web/c.tmpl", true},
+		{"web/**/*.tmpl", "api/c.tmpl", false},
+		{"**", "any/thing", true},
+		{"a/[bc]?/d", "a/cx/d", true},
+	} {
+		if gommm.MatchGlob(tc.pattern, tc.name) != tc.match {
+			t.Errorf("MatchGlob(%q, %q) expected %v", tc.pattern, tc.name, tc.match)
+		}
+	}
+}
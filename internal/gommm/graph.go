@@ -0,0 +1,293 @@
+package gommm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Graph is the set of source files a package is built from, derived from `go list -deps`.
+// Only packages of the main module and of modules replaced by a local directory are part
+// of it, the standard library and the module cache never change.
+type Graph struct {
+	dir      string
+	targets  []string
+	tests    bool
+	environ  []string
+	mu       sync.Mutex
+	pkgDirs  map[string]bool
+	pkgs     map[string][]string
+	testDeps map[string][]string
+	files    map[string]bool
+	embeds   []string
+	roots    []string
+	imports  map[string]string
+}
+
+// listPackage is the part of the `go list -json` output the graph is built from
+type listPackage struct {
+	Dir           string
+	ImportPath    string
+	Name          string
+	Standard      bool
+	Deps          []string
+	GoFiles       []string
+	CgoFiles      []string
+	CFiles        []string
+	CXXFiles      []string
+	HFiles        []string
+	SFiles        []string
+	EmbedFiles    []string
+	EmbedPatterns []string
+	TestGoFiles   []string
+	XTestGoFiles  []string
+	Module        *struct {
+		Path    string
+		Main    bool
+		Dir     string
+		GoMod   string
+		Replace *struct {
+			Path    string
+			Version string
+		}
+	}
+}
+
+// local reports whether the package is part of a module edited locally
+func (p *listPackage) local() bool {
+	if p.Standard || p.Module == nil {
+		return false
+	}
+	return p.Module.Main || (p.Module.Replace != nil && p.Module.Replace.Version == "")
+}
+
+// NewGraph constructor, targets are the packages built in dir
+func NewGraph(dir string, targets ...string) *Graph {
+	return &Graph{dir: dir, targets: targets}
+}
+
+// NewTestGraph constructor, a graph of the packages tested in dir including their test files
+func NewTestGraph(dir string, targets ...string) *Graph {
+	return &Graph{dir: dir, targets: targets, tests: true}
+}
+
+// SetEnviron replaces the environment go list runs with, gommm's own when nil
+func (g *Graph) SetEnviron(env []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.environ = env
+}
+
+// Load runs go list and replaces the graph with its result
+func (g *Graph) Load(ctx context.Context) error {
+	args := []string{"list", "-e", "-deps", "-json"}
+	if g.tests {
+		args = append(args, "-test")
+	}
+	cmd := exec.CommandContext(ctx, "go", append(args, g.targets...)...)
+	cmd.Dir = g.dir
+	g.mu.Lock()
+	cmd.Env = g.environ
+	g.mu.Unlock()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	target := strings.Join(g.targets, " ")
+	if err != nil {
+		return fmt.Errorf("go list %s err:%v\n%s", target, err, stderr.String())
+	}
+	pkgDirs := make(map[string]bool)
+	pkgs := make(map[string][]string)
+	testDeps := make(map[string][]string)
+	files := make(map[string]bool)
+	imports := make(map[string]string)
+	modules := make(map[string]bool)
+	var embeds []string
+	dec := json.NewDecoder(bytes.NewReader(output))
+	for {
+		var pkg listPackage
+		if err := dec.Decode(&pkg); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("go list %s output err:%v", target, err)
+		}
+		// the generated main package of a test binary depends on everything the test does
+		if g.tests && pkg.Name == "main" && strings.HasSuffix(pkg.ImportPath, ".test") {
+			deps := make([]string, 0, len(pkg.Deps))
+			for _, dep := range pkg.Deps {
+				deps = append(deps, stripVariant(dep))
+			}
+			testDeps[strings.TrimSuffix(pkg.ImportPath, ".test")] = deps
+			continue
+		}
+		if !pkg.local() {
+			continue
+		}
+		pkgDirs[pkg.Dir] = true
+		pkgs[pkg.Dir] = append(pkgs[pkg.Dir], stripVariant(pkg.ImportPath))
+		sources := append(pkg.GoFiles, pkg.CgoFiles...)
+		if g.tests {
+			sources = append(append(sources, pkg.TestGoFiles...), pkg.XTestGoFiles...)
+		}
+		for _, list := range [][]string{sources, pkg.CFiles, pkg.CXXFiles, pkg.HFiles, pkg.SFiles, pkg.EmbedFiles} {
+			for _, name := range list {
+				files[filepath.Join(pkg.Dir, name)] = true
+			}
+		}
+		for _, name := range sources {
+			file := filepath.Join(pkg.Dir, name)
+			imports[file] = fileImports(file)
+		}
+		// files added later below an embedded directory are embedded as well
+		for _, pattern := range pkg.EmbedPatterns {
+			pattern = strings.TrimPrefix(pattern, "all:")
+			if !strings.ContainsAny(pattern, "*?[") {
+				embeds = append(embeds, filepath.Join(pkg.Dir, pattern)+string(filepath.Separator))
+			}
+		}
+		if gomod := pkg.Module.GoMod; gomod != "" && !modules[gomod] {
+			modules[gomod] = true
+			files[gomod] = true
+			if pkg.Module.Main {
+				files[filepath.Join(filepath.Dir(gomod), "go.sum")] = true
+			}
+		}
+	}
+	roots := make([]string, 0, len(modules))
+	for gomod := range modules {
+		roots = append(roots, filepath.Dir(gomod))
+	}
+	sort.Strings(roots)
+	g.mu.Lock()
+	g.pkgDirs, g.pkgs, g.testDeps = pkgDirs, pkgs, testDeps
+	g.files, g.imports, g.embeds, g.roots = files, imports, embeds, roots
+	g.mu.Unlock()
+	return nil
+}
+
+// stripVariant removes the test variant suffix of an import path, e.g. "p [p.test]"
+func stripVariant(importPath string) string {
+	if i := strings.Index(importPath, " ["); i > 0 {
+		return importPath[:i]
+	}
+	return importPath
+}
+
+// Affected returns the sorted import paths of the packages with tests of a test graph
+// whose tests depend on the changed paths
+func (g *Graph) Affected(paths []string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	all := false
+	changed := make(map[string]bool)
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		switch filepath.Base(abs) {
+		case "go.mod", "go.sum":
+			all = true
+		}
+		for _, pkg := range g.pkgs[filepath.Dir(abs)] {
+			changed[pkg] = true
+		}
+	}
+	var affected []string
+	for pkg, deps := range g.testDeps {
+		hit := all || changed[pkg]
+		for _, dep := range deps {
+			hit = hit || changed[dep]
+		}
+		if hit {
+			affected = append(affected, pkg)
+		}
+	}
+	sort.Strings(affected)
+	return affected
+}
+
+// Roots are the directories of the local modules the graph spans
+func (g *Graph) Roots() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.roots
+}
+
+// Contains reports whether a change to path affects the build, or the tests of a test graph.
+// Besides the files listed by go list this is every .go file in a package directory,
+// as a file can be added to a package or a build constraint edited.
+func (g *Graph) Contains(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.files[abs] {
+		return true
+	}
+	if g.goFile(abs) && g.pkgDirs[filepath.Dir(abs)] {
+		return true
+	}
+	for _, prefix := range g.embeds {
+		if strings.HasPrefix(abs, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportsChanged reports whether the changed paths may alter the graph:
+// a go.mod was edited, or a .go file of it was added, removed or changed its imports.
+func (g *Graph) ImportsChanged(paths []string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		if filepath.Base(abs) == "go.mod" {
+			return true
+		}
+		if !g.goFile(abs) {
+			continue
+		}
+		recorded, ok := g.imports[abs]
+		if !ok || fileImports(abs) != recorded {
+			return true
+		}
+	}
+	return false
+}
+
+// goFile reports whether path is a .go file of the graph, test files only belong to test graphs
+func (g *Graph) goFile(path string) bool {
+	return filepath.Ext(path) == ".go" && (g.tests || !strings.HasSuffix(path, "_test.go"))
+}
+
+// fileImports returns the sorted imports of a go file, or the empty string if it cannot be read
+func fileImports(file string) string {
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.ImportsOnly)
+	if err != nil {
+		return ""
+	}
+	imports := make([]string, 0, len(f.Imports))
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		imports = append(imports, path)
+	}
+	sort.Strings(imports)
+	return strings.Join(imports, " ")
+}
@@ -0,0 +1,129 @@
+package gommm_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_Graph(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_graph")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+	app, lib := filepath.Join(dir, "app"), filepath.Join(dir, "lib")
+	writeFiles(t, app, map[string]string{
+		"go.mod":            "module app\n\ngo 1.16\n\nrequire lib v0.0.0\n\nreplace lib => ../lib\n",
+		"main.go":           "package main\n\nimport (\n\t_ \"embed\"\n\n\t\"app/util\"\n\t\"lib\"\n)\n\n//go:embed static\nvar static string\n\nfunc main() { util.F(); lib.F() }\n",
+		"static":            "embedded\n",
+		"util/util.go":      "package util\n\nfunc F() {}\n",
+		"util/util_test.go": "package util\n",
+		"cmd/other/main.go": "package main\n\nfunc main() {}\n",
+	})
+	writeFiles(t, lib, map[string]string{
+		"go.mod": "module lib\n\ngo 1.16\n",
+		"lib.go": "package lib\n\nfunc F() {}\n",
+	})
+
+	graph := gommm.NewGraph(app, ".")
+	err = graph.Load(context.Background())
+	expect(t, err, nil)
+	expect(t, strings.Join(graph.Roots(), " "), app+" "+lib)
+
+	for _, file := range []string{"main.go", "static", "go.mod", "go.sum", "util/util.go", "util/new.go", "../lib/lib.go", "../lib/go.mod"} {
+		expect(t, graph.Contains(filepath.Join(app, file)), true)
+	}
+	for _, file := range []string{"cmd/other/main.go", "util/util_test.go", "README.md"} {
+		expect(t, graph.Contains(filepath.Join(app, file)), false)
+	}
+
+	main := filepath.Join(app, "main.go")
+	expect(t, graph.ImportsChanged([]string{main}), false)
+	writeFiles(t, app, map[string]string{
+		"main.go": "package main\n\nimport (\n\t_ \"embed\"\n\n\t\"app/util\"\n\t\"lib\"\n)\n\n//go:embed static\nvar static string\n\nfunc main() { util.F(); lib.F(); println() }\n",
+	})
+	expect(t, graph.ImportsChanged([]string{main}), false)
+	writeFiles(t, app, map[string]string{
+		"main.go": "package main\n\nimport \"app/cmd/other\"\n\nfunc main() { other.F() }\n",
+	})
+	expect(t, graph.ImportsChanged([]string{main}), true)
+	expect(t, graph.ImportsChanged([]string{filepath.Join(app, "util", "new.go")}), true)
+	expect(t, graph.ImportsChanged([]string{filepath.Join(app, "go.mod")}), true)
+}
+
+func Test_Graph_Affected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_graph")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+	writeFiles(t, dir, map[string]string{
+		"go.mod":                "module app\n",
+		"base/base.go":          "package base\n",
+		"api/api.go":            "package api\n\nimport _ \"app/base\"\n",
+		"api/api_test.go":       "package api\n",
+		"worker/worker.go":      "package worker\n",
+		"worker/worker_test.go": "package worker_test\n\nimport _ \"app/fixture\"\n",
+		"fixture/fixture.go":    "package fixture\n\nimport _ \"app/base\"\n",
+		"other/other_test.go":   "package other\n",
+	})
+
+	graph := gommm.NewTestGraph(dir, "./...")
+	err = graph.Load(context.Background())
+	expect(t, err, nil)
+	affected := func(files ...string) string {
+		for i := range files {
+			files[i] = filepath.Join(dir, files[i])
+		}
+		return strings.Join(graph.Affected(files), " ")
+	}
+	expect(t, graph.Contains(filepath.Join(dir, "api", "api_test.go")), true)
+	expect(t, affected("base/base.go"), "app/api app/worker")
+	expect(t, affected("fixture/fixture.go"), "app/worker")
+	expect(t, affected("api/api_test.go"), "app/api")
+	expect(t, affected("worker/worker.go"), "app/worker")
+	expect(t, affected("go.mod"), "app/api app/other app/worker")
+}
+
+func Test_Graph_Environ(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_graph")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, _ = filepath.EvalSymlinks(dir)
+	writeFiles(t, dir, map[string]string{
+		"go.mod":         "module app\n",
+		"main.go":        "package main\n\nfunc main() {}\n",
+		"tagged.go":      "// +build envtag\n\npackage main\n\nimport _ \"app/extra\"\n",
+		"extra/extra.go": "package extra\n",
+	})
+
+	// go list only follows the import of tagged with the GOFLAGS of the environment
+	graph := gommm.NewGraph(dir, ".")
+	expect(t, graph.Load(context.Background()), nil)
+	expect(t, graph.Contains(filepath.Join(dir, "extra", "extra.go")), false)
+	graph.SetEnviron(gommm.MergeEnv(os.Environ(), []string{"GOFLAGS=-tags=envtag"}))
+	expect(t, graph.Load(context.Background()), nil)
+	expect(t, graph.Contains(filepath.Join(dir, "extra", "extra.go")), true)
+}
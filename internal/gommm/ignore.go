@@ -0,0 +1,94 @@
+package gommm
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFiles are read in every directory, rules of later files take precedence
+var ignoreFiles = []string{".gitignore", ".ignore"}
+
+// ignoreRule is one pattern of a .gitignore file, relative to the directory of that file
+type ignoreRule struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// parseIgnore reads rules following the gitignore format
+func parseIgnore(r io.Reader) []ignoreRule {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// trailing spaces are ignored unless escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rule := ignoreRule{}
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\#") || strings.HasPrefix(line, "\\!") {
+			line = line[1:]
+		}
+		line = strings.Replace(line, "\\ ", " ", -1)
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// a slash at the beginning or in the middle anchors the pattern to the directory
+		if strings.Contains(line, "/") {
+			rule.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// match reports whether the slash separated rel, relative to the directory of the rule, matches
+func (r ignoreRule) match(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.anchored {
+		return MatchGlob(r.pattern, rel)
+	}
+	return MatchGlob(r.pattern, rel[strings.LastIndex(rel, "/")+1:])
+}
+
+// readIgnore reads the rules of the ignore files in dir
+func readIgnore(dir string) []ignoreRule {
+	var rules []ignoreRule
+	for _, name := range ignoreFiles {
+		fr, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		rules = append(rules, parseIgnore(fr)...)
+		fr.Close()
+	}
+	return rules
+}
+
+func isIgnoreFile(path string) bool {
+	base := filepath.Base(path)
+	for _, name := range ignoreFiles {
+		if base == name {
+			return true
+		}
+	}
+	return false
+}
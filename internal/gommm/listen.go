@@ -0,0 +1,54 @@
+package gommm
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// Listen opens a tcp listening socket on each address, returned as files to hand to a runner.
+// The sockets stay open as long as the files, so connections queue while the app restarts.
+func Listen(addrs []string) ([]*os.File, error) {
+	var files []*os.File
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			closeFiles(files)
+			return nil, fmt.Errorf("Unable to listen on %s err:%v", addr, err)
+		}
+		file, err := ln.(*net.TCPListener).File()
+		ln.Close()
+		if err != nil {
+			closeFiles(files)
+			return nil, fmt.Errorf("Unable to hand over %s err:%v", addr, err)
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// listensOn reports whether one of the listening sockets of files accepts the connections to addr
+func listensOn(files []*os.File, addr string) bool {
+	tcp, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return false
+	}
+	for _, file := range files {
+		ln, err := net.FileListener(file)
+		if err != nil {
+			continue
+		}
+		local, ok := ln.Addr().(*net.TCPAddr)
+		ln.Close()
+		if ok && local.Port == tcp.Port && (local.IP.IsUnspecified() || local.IP.Equal(tcp.IP)) {
+			return true
+		}
+	}
+	return false
+}
+
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+	}
+}
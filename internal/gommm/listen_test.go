@@ -0,0 +1,27 @@
+package gommm_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_Listen(t *testing.T) {
+	files, err := gommm.Listen([]string{"localhost:0", "localhost:0"})
+	expect(t, err, nil)
+	expect(t, len(files), 2)
+	for _, file := range files {
+		// the socket accepts connections through the file
+		ln, err := net.FileListener(file)
+		expect(t, err, nil)
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		expect(t, err, nil)
+		conn.Close()
+		ln.Close()
+		file.Close()
+	}
+
+	_, err = gommm.Listen([]string{"localhost:-1"})
+	refute(t, err, nil)
+}
@@ -0,0 +1,140 @@
+package gommm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LiveReloadPath is the server-sent events endpoint pages injected with the live-reload script connect to
+const LiveReloadPath = "/__gommm/livereload"
+
+var liveReloadScript = []byte(`<script>(function(){var es=new EventSource("` + LiveReloadPath + `");es.addEventListener("reload",function(){es.close();location.reload()});})();</script>`)
+
+// liveReload broadcasts reload events to the connected pages
+type liveReload struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]bool
+}
+
+func newLiveReload() *liveReload {
+	return &liveReload{clients: make(map[chan struct{}]bool)}
+}
+
+func (lr *liveReload) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		http.Error(res, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	reload := make(chan struct{}, 1)
+	lr.mu.Lock()
+	lr.clients[reload] = true
+	lr.mu.Unlock()
+	defer func() {
+		lr.mu.Lock()
+		delete(lr.clients, reload)
+		lr.mu.Unlock()
+	}()
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprint(res, ": connected\n\n")
+	flusher.Flush()
+	ping := time.NewTicker(15 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ping.C:
+			fmt.Fprint(res, ": ping\n\n")
+		case <-reload:
+			fmt.Fprint(res, "event: reload\ndata: {}\n\n")
+		}
+		flusher.Flush()
+	}
+}
+
+func (lr *liveReload) notify() {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for reload := range lr.clients {
+		select {
+		case reload <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// inject adds the live-reload script to html responses,
+// decoding and re-encoding gzip bodies and updating the length.
+func (lr *liveReload) inject(res *http.Response) error {
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") ||
+		res.Request.Method == http.MethodHead ||
+		res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	encoding := res.Header.Get("Content-Encoding")
+	if encoding != "" && encoding != "gzip" {
+		return nil
+	}
+	var body io.Reader = res.Body
+	if encoding == "gzip" {
+		gz, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return err
+		}
+		body = gz
+	}
+	page, err := ioutil.ReadAll(body)
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+	page = injectScript(page, liveReloadScript)
+	if encoding == "gzip" {
+		buf := bytes.Buffer{}
+		gz := gzip.NewWriter(&buf)
+		gz.Write(page)
+		gz.Close()
+		page = buf.Bytes()
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(page))
+	res.ContentLength = int64(len(page))
+	res.Header.Set("Content-Length", strconv.Itoa(len(page)))
+	return nil
+}
+
+// injectScript inserts script before the closing body tag, or appends it if there is none.
+// The page is returned as is without a script.
+func injectScript(page []byte, script []byte) []byte {
+	if len(script) == 0 {
+		return page
+	}
+	i := lastIndexFold(page, []byte("</body>"))
+	if i < 0 {
+		return append(page, script...)
+	}
+	out := make([]byte, 0, len(page)+len(script))
+	out = append(out, page[:i]...)
+	out = append(out, script...)
+	return append(out, page[i:]...)
+}
+
+func lastIndexFold(s []byte, sep []byte) int {
+	for i := len(s) - len(sep); i >= 0; i-- {
+		if bytes.EqualFold(s[i:i+len(sep)], sep) {
+			return i
+		}
+	}
+	return -1
+}
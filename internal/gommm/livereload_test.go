@@ -0,0 +1,111 @@
+package gommm_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_LiveReload_Inject(t *testing.T) {
+	builder := NewMockBuilder()
+	runner := NewMockRunner()
+	proxy := gommm.NewProxy(builder, runner)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		page := []byte("<html><body>Hello world</BODY></html>")
+		if r.URL.Path == "/gzip" {
+			buf := bytes.Buffer{}
+			gz := gzip.NewWriter(&buf)
+			gz.Write(page)
+			gz.Close()
+			page = buf.Bytes()
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+		w.Write(page)
+	}))
+	defer ts.Close()
+
+	config := &gommm.Config{
+		Port:       5682,
+		ProxyTo:    ts.URL,
+		LiveReload: true,
+	}
+
+	err := proxy.Run(config)
+	defer proxy.Close()
+	expect(t, err, nil)
+
+	res, err := http.Get("http://localhost:5682/")
+	expect(t, err, nil)
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, res.ContentLength, int64(len(body)))
+	expect(t, strings.Contains(string(body), gommm.LiveReloadPath), true)
+	expect(t, strings.HasSuffix(string(body), "</script></BODY></html>"), true)
+
+	req, _ := http.NewRequest("GET", "http://localhost:5682/gzip", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err = http.DefaultClient.Do(req)
+	expect(t, err, nil)
+	expect(t, res.Header.Get("Content-Encoding"), "gzip")
+	raw, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	expect(t, res.ContentLength, int64(len(raw)))
+	gz, err := gzip.NewReader(bytes.NewReader(raw))
+	expect(t, err, nil)
+	body, _ = ioutil.ReadAll(gz)
+	expect(t, strings.Contains(string(body), gommm.LiveReloadPath), true)
+}
+
+func Test_LiveReload_Events(t *testing.T) {
+	builder := NewMockBuilder()
+	runner := NewMockRunner()
+	proxy := gommm.NewProxy(builder, runner)
+
+	config := &gommm.Config{
+		Port:       5683,
+		ProxyTo:    "http://localhost:3000",
+		LiveReload: true,
+	}
+
+	err := proxy.Run(config)
+	defer proxy.Close()
+	expect(t, err, nil)
+
+	res, err := http.Get(fmt.Sprintf("http://localhost:5683%s", gommm.LiveReloadPath))
+	expect(t, err, nil)
+	defer res.Body.Close()
+	expect(t, res.Header.Get("Content-Type"), "text/event-stream")
+	events := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "event: ") {
+				events <- scanner.Text()
+			}
+		}
+	}()
+
+	// the first line is written once the client is registered
+	time.Sleep(50 * time.Millisecond)
+	proxy.Reload()
+	select {
+	case ev := <-events:
+		expect(t, ev, "event: reload")
+	case <-time.After(2 * time.Second):
+		t.Fatal("No reload event received")
+	}
+	expect(t, runner.DidRun, false)
+}
@@ -1,9 +1,13 @@
 package gommm_test
 
 import (
+	"context"
 	"io"
 	"os"
 	"os/exec"
+	"time"
+
+	"github.com/wxio/gommm/internal/gommm"
 )
 
 type MockRunner struct {
@@ -28,26 +32,91 @@ func (m *MockRunner) Info() (os.FileInfo, error) {
 func (m *MockRunner) SetWriter(io.Writer) {
 }
 
+func (m *MockRunner) SetEnv(env []string) {
+}
+
+func (m *MockRunner) SetEnviron(env []string) {
+}
+
+func (m *MockRunner) Environ() []string {
+	return nil
+}
+
+func (m *MockRunner) SetRestart(*gommm.Restart) {
+}
+
+func (m *MockRunner) SetStop(os.Signal, time.Duration) {
+}
+
+func (m *MockRunner) SetListeners([]*os.File) {
+}
+
+func (m *MockRunner) Replace() (*exec.Cmd, error) {
+	m.DidRun = true
+	return nil, nil
+}
+
+func (m *MockRunner) SetReadiness(*gommm.Readiness) {
+}
+
 func (m *MockRunner) Kill() error {
 	return nil
 }
 
 type MockBuilder struct {
+	MockDir    string
 	MockErrors string
+	MockState  gommm.BuildState
 }
 
 func NewMockBuilder() *MockBuilder {
-	return &MockBuilder{}
+	return &MockBuilder{
+		MockState: gommm.BuildOK,
+	}
 }
 
 func (m *MockBuilder) Binary() string {
 	return "bin"
 }
 
-func (m *MockBuilder) Build() error {
+func (m *MockBuilder) Diagnostics() []gommm.Diagnostic {
+	return gommm.ParseDiagnostics(m.MockErrors, m.MockDir)
+}
+
+func (m *MockBuilder) Build(ctx context.Context) error {
 	return nil
 }
 
 func (m *MockBuilder) Errors() string {
 	return m.MockErrors
 }
+
+func (m *MockBuilder) SetEnviron(env []string) {
+}
+
+func (m *MockBuilder) SetRelease(release func() error) {
+}
+
+func (m *MockBuilder) SetSteps(pre []gommm.Step, post []gommm.Step) {
+}
+
+func (m *MockBuilder) SetVerify(vet bool, test bool) {
+}
+
+func (m *MockBuilder) Changed(paths []string) {
+}
+
+func (m *MockBuilder) Steps() []gommm.StepResult {
+	return nil
+}
+
+func (m *MockBuilder) State() gommm.BuildState {
+	return m.MockState
+}
+
+func (m *MockBuilder) Wait(timeout time.Duration) gommm.BuildState {
+	if m.MockState == gommm.BuildBuilding {
+		time.Sleep(timeout)
+	}
+	return m.MockState
+}
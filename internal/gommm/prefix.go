@@ -0,0 +1,43 @@
+package gommm
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// prefixWriter writes complete lines to the underlying writer, each preceded by a prefix,
+// so the output of several processes can be interleaved. An incomplete line is held back
+// until it is completed.
+type prefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	mu     sync.Mutex
+	buf    []byte
+}
+
+// NewPrefixWriter constructor
+func NewPrefixWriter(w io.Writer, prefix string) io.Writer {
+	return &prefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf = append(p.buf, b...)
+	i := bytes.LastIndexByte(p.buf, '\n')
+	if i < 0 {
+		return len(b), nil
+	}
+	var out []byte
+	for _, line := range bytes.SplitAfter(p.buf[:i+1], []byte("\n")) {
+		if len(line) > 0 {
+			out = append(append(out, p.prefix...), line...)
+		}
+	}
+	p.buf = append(p.buf[:0], p.buf[i+1:]...)
+	if _, err := p.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
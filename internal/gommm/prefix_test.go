@@ -0,0 +1,17 @@
+package gommm_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_PrefixWriter(t *testing.T) {
+	buff := &bytes.Buffer{}
+	w := gommm.NewPrefixWriter(buff, "api | ")
+	w.Write([]byte("one\ntw"))
+	expect(t, buff.String(), "api | one\n")
+	w.Write([]byte("o\nthree\n"))
+	expect(t, buff.String(), "api | one\napi | two\napi | three\n")
+}
@@ -0,0 +1,80 @@
+//go:build !windows
+// +build !windows
+
+package gommm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// setProcessGroup starts cmd in a new process group so it can be signalled together with its children
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// listenCommand runs bin through a shell that sets LISTEN_PID to its own pid before replacing itself
+// with bin, as socket activation expects LISTEN_PID to be the pid of the process using the sockets
+func listenCommand(bin string, args ...string) *exec.Cmd {
+	return exec.Command("sh", append([]string{"-c", `LISTEN_PID=$$ exec "$0" "$@"`, bin}, args...)...)
+}
+
+// signalProcessGroup sends sig to cmd and every process of its group
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return cmd.Process.Signal(sig)
+	}
+	return syscall.Kill(-cmd.Process.Pid, s)
+}
+
+// killProcessGroup kills cmd and every process of its group
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// processGroupExited waits up to timeout for every process of the group of cmd to exit
+func processGroupExited(cmd *exec.Cmd, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for processGroupRunning(cmd.Process.Pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// processGroupRunning reports whether a process of group pgid is running. Where there is a /proc,
+// zombies left behind by an init that does not reap them are not counted.
+func processGroupRunning(pgid int) bool {
+	if err := syscall.Kill(-pgid, 0); err == syscall.ESRCH {
+		return false
+	}
+	stats, _ := filepath.Glob("/proc/[0-9]*/stat")
+	if len(stats) == 0 {
+		return true
+	}
+	for _, stat := range stats {
+		b, err := ioutil.ReadFile(stat)
+		if err != nil {
+			continue
+		}
+		// pid (comm) state ppid pgrp ..., comm may contain spaces and parentheses
+		fields := strings.Fields(string(b[bytes.LastIndexByte(b, ')')+1:]))
+		if len(fields) > 2 && fields[0] != "Z" && fields[2] == strconv.Itoa(pgid) {
+			return true
+		}
+	}
+	return false
+}
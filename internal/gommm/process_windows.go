@@ -0,0 +1,28 @@
+package gommm
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// listenCommand runs bin, windows cannot hand sockets to it
+func listenCommand(bin string, args ...string) *exec.Cmd {
+	return exec.Command(bin, args...)
+}
+
+// signalProcessGroup kills cmd, windows has no signals to ask it to stop
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	return cmd.Process.Kill()
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func processGroupExited(cmd *exec.Cmd, timeout time.Duration) bool {
+	return true
+}
@@ -0,0 +1,148 @@
+package gommm
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Process is one of several binaries built and run side by side
+type Process struct {
+	Name string `json:"name"`
+	// Build is the directory of the main package
+	Build string   `json:"build"`
+	Args  []string `json:"args"`
+	// Env holds KEY=VALUE pairs added to the environment of the process
+	Env []string `json:"env"`
+	// Restart holds globs of files whose changes restart the process without rebuilding it
+	Restart []string `json:"restart"`
+	// Policy for restarting the process when it exits on its own, overriding the default.
+	// Only a json procfile sets it.
+	Policy string `json:"policy"`
+	// StopSignal and StopTimeout in seconds for stopping the process, overriding the defaults.
+	// Only a json procfile sets them.
+	StopSignal  string `json:"stop_signal"`
+	StopTimeout int    `json:"stop_timeout"`
+}
+
+var (
+	procfileName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+	procfileEnv  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*=`)
+)
+
+// LoadProcfile reads processes from a Procfile with lines of
+//
+//	name: [KEY=VALUE ...] build-dir [args ...]
+//
+// or, when path ends in .json, from a json file {"processes": [...]}
+func LoadProcfile(path string) ([]Process, error) {
+	fr, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read procfile %s", path)
+	}
+	defer fr.Close()
+	var procs []Process
+	if strings.HasSuffix(path, ".json") {
+		file := struct {
+			Processes []Process `json:"processes"`
+		}{}
+		if err := json.NewDecoder(fr).Decode(&file); err != nil {
+			return nil, fmt.Errorf("Unable to parse procfile %s err:%v", path, err)
+		}
+		procs = file.Processes
+	} else {
+		scanner := bufio.NewScanner(fr)
+		for n := 1; scanner.Scan(); n++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || line[0] == '#' {
+				continue
+			}
+			i := strings.Index(line, ":")
+			if i < 0 {
+				return nil, fmt.Errorf("%s:%d: expected name: build-dir [args ...]", path, n)
+			}
+			words, err := splitWords(line[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+			}
+			proc := Process{Name: strings.TrimSpace(line[:i])}
+			for len(words) > 0 && procfileEnv.MatchString(words[0]) {
+				proc.Env, words = append(proc.Env, words[0]), words[1:]
+			}
+			if len(words) > 0 {
+				proc.Build, proc.Args = words[0], words[1:]
+			}
+			procs = append(procs, proc)
+		}
+	}
+	if len(procs) == 0 {
+		return nil, fmt.Errorf("No processes in procfile %s", path)
+	}
+	names := make(map[string]bool)
+	for _, proc := range procs {
+		if !procfileName.MatchString(proc.Name) {
+			return nil, fmt.Errorf("Invalid process name %q in procfile %s", proc.Name, path)
+		}
+		if names[proc.Name] {
+			return nil, fmt.Errorf("Duplicate process %s in procfile %s", proc.Name, path)
+		}
+		names[proc.Name] = true
+		if proc.Build == "" {
+			return nil, fmt.Errorf("Process %s in procfile %s has no build directory", proc.Name, path)
+		}
+		if _, err := ParseRestartPolicy(proc.Policy); err != nil {
+			return nil, fmt.Errorf("Process %s in procfile %s has an %v", proc.Name, path, err)
+		}
+		if _, err := ParseSignal(proc.StopSignal); err != nil {
+			return nil, fmt.Errorf("Process %s in procfile %s has an %v", proc.Name, path, err)
+		}
+	}
+	return procs, nil
+}
+
+// splitWords splits s at spaces like a shell, minus expansions
+func splitWords(s string) ([]string, error) {
+	var (
+		words []string
+		word  strings.Builder
+		quote rune
+		in    bool
+		esc   bool
+	)
+	for _, c := range s {
+		switch {
+		case esc:
+			word.WriteRune(c)
+			esc = false
+		case c == '\\' && quote != '\'':
+			esc, in = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, in = c, true
+		case c == ' ' || c == '\t':
+			if in {
+				words = append(words, word.String())
+				word.Reset()
+				in = false
+			}
+		default:
+			word.WriteRune(c)
+			in = true
+		}
+	}
+	if quote != 0 || esc {
+		return nil, fmt.Errorf("unterminated quote or escape")
+	}
+	if in {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
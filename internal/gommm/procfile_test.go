@@ -0,0 +1,58 @@
+package gommm_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_LoadProcfile(t *testing.T) {
+	procs, err := gommm.LoadProcfile("test_fixtures/Procfile")
+	expect(t, err, nil)
+	expect(t, len(procs), 2)
+	expect(t, procs[0].Name, "api")
+	expect(t, procs[0].Build, "./cmd/api")
+	expect(t, strings.Join(procs[0].Args, ","), "--port,8080")
+	expect(t, procs[1].Name, "worker")
+	expect(t, strings.Join(procs[1].Env, ","), "QUEUE=high,LOG=debug json")
+	expect(t, procs[1].Build, "./cmd/worker")
+	expect(t, strings.Join(procs[1].Args, ","), "-name,night shift")
+}
+
+func Test_LoadProcfile_Json(t *testing.T) {
+	procs, err := gommm.LoadProcfile("test_fixtures/procfile.json")
+	expect(t, err, nil)
+	expect(t, len(procs), 2)
+	expect(t, procs[0].StopSignal, "SIGTERM")
+	expect(t, procs[0].StopTimeout, 10)
+	expect(t, procs[1].Name, "scheduler")
+	expect(t, procs[1].Env[0], "TICK=1s")
+	expect(t, procs[1].Restart[0], "config/*.yaml")
+	expect(t, procs[1].Policy, "on-failure")
+}
+
+func Test_LoadProcfile_Invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_procfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for content, msg := range map[string]string{
+		"api ./cmd/api\n":                   "Procfile:1: expected name: build-dir [args ...]",
+		"api: ./cmd/api 'open\n":            "Procfile:1: unterminated quote or escape",
+		"api: ./cmd/api\napi: ./cmd/api2\n": "Duplicate process api in procfile",
+		"api: A=1\n":                        "Process api in procfile",
+		"# nothing\n":                       "No processes in procfile",
+		"the api: ./cmd/api\n":              "Invalid process name \"the api\"",
+	} {
+		file := filepath.Join(dir, "Procfile")
+		ioutil.WriteFile(file, []byte(content), 0644)
+		_, err := gommm.LoadProcfile(file)
+		refute(t, err, nil)
+		expect(t, strings.Contains(err.Error(), msg), true)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"time"
 )
 
 type Proxy struct {
@@ -17,6 +19,8 @@ type Proxy struct {
 	builder  Builder
 	runner   Runner
 	to       *url.URL
+	timeout  time.Duration
+	reload   *liveReload
 }
 
 func NewProxy(builder Builder, runner Runner) *Proxy {
@@ -35,6 +39,14 @@ func (p *Proxy) Run(config *Config) error {
 	}
 	p.proxy = httputil.NewSingleHostReverseProxy(url)
 	p.to = url
+	p.timeout = 30 * time.Second
+	if config.Timeout > 0 {
+		p.timeout = time.Duration(config.Timeout) * time.Second
+	}
+	if config.LiveReload {
+		p.reload = newLiveReload()
+		p.proxy.ModifyResponse = p.reload.inject
+	}
 
 	server := http.Server{Handler: http.HandlerFunc(p.defaultHandler)}
 
@@ -66,12 +78,44 @@ func (p *Proxy) Close() error {
 	return p.listener.Close()
 }
 
+// Reload tells the pages connected for live-reload to reload themselves
+func (p *Proxy) Reload() {
+	if p.reload != nil {
+		p.reload.notify()
+	}
+}
+
+// script returns the live-reload script the pages of gommm itself include, nil without live-reload
+func (p *Proxy) script() []byte {
+	if p.reload == nil {
+		return nil
+	}
+	return liveReloadScript
+}
+
 func (p *Proxy) defaultHandler(res http.ResponseWriter, req *http.Request) {
+	if p.reload != nil && req.URL.Path == LiveReloadPath {
+		p.reload.ServeHTTP(res, req)
+		return
+	}
+	// hold the request until the running build is done
+	if state := p.builder.Wait(p.timeout); state != BuildOK && state != BuildFailed {
+		res.Header().Set("Content-Type", "text/html; charset=utf-8")
+		res.Header().Set("Retry-After", "1")
+		res.WriteHeader(http.StatusServiceUnavailable)
+		res.Write(injectScript([]byte(fmt.Sprintf(unavailablePage, p.timeout)), p.script()))
+		return
+	}
 	errors := p.builder.Errors()
 	if len(errors) > 0 {
-		res.Write([]byte(errors))
+		writeBuildErrors(res, req, errors, p.builder.Diagnostics(), p.script())
 	} else {
-		p.runner.Run()
+		if _, err := p.runner.Run(); err != nil {
+			res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			res.WriteHeader(http.StatusBadGateway)
+			fmt.Fprintf(res, "the application failed to start: %v\n", err)
+			return
+		}
 		if strings.ToLower(req.Header.Get("Upgrade")) == "websocket" || strings.ToLower(req.Header.Get("Accept")) == "text/event-stream" {
 			proxyWebsocket(res, req, p.to)
 		} else {
@@ -84,7 +128,7 @@ func proxyWebsocket(w http.ResponseWriter, r *http.Request, host *url.URL) {
 	d, err := net.Dial("tcp", host.Host)
 	if err != nil {
 		http.Error(w, "Error contacting backend server.", 500)
-		fmt.Errorf("Error dialing websocket backend %s: %v", host, err)
+		log.Printf("Error dialing websocket backend %s: %v", host, err)
 		return
 	}
 	hj, ok := w.(http.Hijacker)
@@ -94,7 +138,7 @@ func proxyWebsocket(w http.ResponseWriter, r *http.Request, host *url.URL) {
 	}
 	nc, _, err := hj.Hijack()
 	if err != nil {
-		fmt.Errorf("Hijack error: %v", err)
+		log.Printf("Hijack error: %v", err)
 		return
 	}
 	defer nc.Close()
@@ -102,7 +146,7 @@ func proxyWebsocket(w http.ResponseWriter, r *http.Request, host *url.URL) {
 
 	err = r.Write(d)
 	if err != nil {
-		fmt.Errorf("Error copying request to target: %v", err)
+		log.Printf("Error copying request to target: %v", err)
 		return
 	}
 
@@ -115,3 +159,13 @@ func proxyWebsocket(w http.ResponseWriter, r *http.Request, host *url.URL) {
 	go cp(nc, d)
 	<-errc
 }
+
+const unavailablePage = `<!DOCTYPE html>
+<html>
+<head><title>503 Service Unavailable</title></head>
+<body>
+<h1>Service Unavailable</h1>
+<p>gommm is still building the application after %v, reload to keep waiting.</p>
+</body>
+</html>
+`
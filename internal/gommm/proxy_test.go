@@ -1,10 +1,13 @@
 package gommm_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/wxio/gommm/internal/gommm"
@@ -113,3 +116,131 @@ func Test_Proxying_Build_Errors(t *testing.T) {
 	res.Body.Close()
 	expect(t, fmt.Sprintf("%s", errors), "Foo bar here are some errors")
 }
+
+func Test_Proxying_Build_In_Progress(t *testing.T) {
+	builder := NewMockBuilder()
+	builder.MockState = gommm.BuildBuilding
+	runner := NewMockRunner()
+	proxy := gommm.NewProxy(builder, runner)
+
+	config := &gommm.Config{
+		Port:    5680,
+		ProxyTo: "http://localhost:3000",
+		Timeout: 1,
+	}
+
+	err := proxy.Run(config)
+	defer proxy.Close()
+	expect(t, err, nil)
+
+	res, err := http.Get("http://localhost:5680")
+	expect(t, err, nil)
+	expect(t, res == nil, false)
+	res.Body.Close()
+	expect(t, res.StatusCode, http.StatusServiceUnavailable)
+	expect(t, runner.DidRun, false)
+}
+
+func Test_Proxying_Build_Errors_Formats(t *testing.T) {
+	builder := NewMockBuilder()
+	builder.MockDir = filepath.Join("test_fixtures", "build_success")
+	builder.MockErrors = "exit status 1\n# build_success\n./main.go:4:2: undefined: println2\n"
+	runner := NewMockRunner()
+	proxy := gommm.NewProxy(builder, runner)
+
+	config := &gommm.Config{
+		Port:    5681,
+		ProxyTo: "http://localhost:3000",
+	}
+
+	err := proxy.Run(config)
+	defer proxy.Close()
+	expect(t, err, nil)
+
+	get := func(accept string) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", "http://localhost:5681", nil)
+		req.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return res, string(body)
+	}
+
+	res, body := get("text/html,application/xhtml+xml")
+	expect(t, res.StatusCode, http.StatusInternalServerError)
+	expect(t, res.Header.Get("Content-Type"), "text/html; charset=utf-8")
+	expect(t, strings.Contains(body, "undefined: println2"), true)
+	// source snippet read from disk
+	expect(t, strings.Contains(body, `println(&#34;Good to go&#34;)`), true)
+	expect(t, strings.Contains(body, gommm.LiveReloadPath), false)
+
+	res, body = get("application/json")
+	expect(t, res.StatusCode, http.StatusInternalServerError)
+	var report struct {
+		Errors []struct {
+			File    string
+			Line    int
+			Column  int
+			Message string
+		}
+	}
+	err = json.Unmarshal([]byte(body), &report)
+	expect(t, err, nil)
+	expect(t, len(report.Errors), 1)
+	expect(t, report.Errors[0].File, filepath.Join("test_fixtures", "build_success", "main.go"))
+	expect(t, report.Errors[0].Line, 4)
+	expect(t, report.Errors[0].Column, 2)
+	expect(t, report.Errors[0].Message, "undefined: println2")
+
+	res, body = get("text/plain")
+	expect(t, res.StatusCode, http.StatusInternalServerError)
+	expect(t, body, builder.MockErrors)
+}
+
+func Test_Proxying_Build_Errors_LiveReload(t *testing.T) {
+	builder := NewMockBuilder()
+	builder.MockErrors = "Foo bar here are some errors"
+	runner := NewMockRunner()
+	proxy := gommm.NewProxy(builder, runner)
+
+	config := &gommm.Config{
+		Port:       5684,
+		ProxyTo:    "http://localhost:3000",
+		Timeout:    1,
+		LiveReload: true,
+	}
+
+	err := proxy.Run(config)
+	defer proxy.Close()
+	expect(t, err, nil)
+
+	get := func(accept string) (*http.Response, string) {
+		req, _ := http.NewRequest("GET", "http://localhost:5684", nil)
+		req.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		return res, string(body)
+	}
+
+	// the error page reloads once the fix builds
+	res, body := get("text/html")
+	expect(t, res.StatusCode, http.StatusInternalServerError)
+	expect(t, strings.Contains(body, gommm.LiveReloadPath), true)
+	expect(t, strings.HasSuffix(body, "</script></body>\n</html>\n"), true)
+	_, body = get("text/plain")
+	expect(t, body, builder.MockErrors)
+
+	// as does the page holding the request while building
+	builder.MockState = gommm.BuildBuilding
+	res, body = get("text/html")
+	expect(t, res.StatusCode, http.StatusServiceUnavailable)
+	expect(t, strings.Contains(body, gommm.LiveReloadPath), true)
+	expect(t, runner.DidRun, false)
+}
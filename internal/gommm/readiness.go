@@ -0,0 +1,143 @@
+package gommm
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Readiness describes the checks a started process has to pass before it is considered serving.
+// All configured checks have to pass.
+type Readiness struct {
+	// TCP address that has to accept connections, e.g. localhost:3001
+	TCP string
+	// HTTP url that has to respond to a GET with Status
+	HTTP string
+	// Status expected from HTTP (default 200)
+	Status int
+	// Log pattern one line of the process output has to match
+	Log *regexp.Regexp
+	// Timeout for the process to become ready (default 10s)
+	Timeout time.Duration
+}
+
+func (rd *Readiness) timeout() time.Duration {
+	if rd.Timeout > 0 {
+		return rd.Timeout
+	}
+	return 10 * time.Second
+}
+
+func (rd *Readiness) probeTCP() bool {
+	conn, err := net.DialTimeout("tcp", rd.TCP, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func (rd *Readiness) probeHTTP() bool {
+	client := http.Client{Timeout: time.Second}
+	res, err := client.Get(rd.HTTP)
+	if err != nil {
+		return false
+	}
+	res.Body.Close()
+	return res.StatusCode == rd.status()
+}
+
+func (rd *Readiness) status() int {
+	if rd.Status > 0 {
+		return rd.Status
+	}
+	return http.StatusOK
+}
+
+// wait polls the checks until all passed, the process exited, the timeout elapsed or abort was closed
+func (rd *Readiness) wait(bin string, exited <-chan struct{}, logged <-chan struct{}, abort <-chan struct{}) error {
+	tcpOK, httpOK, logOK := rd.TCP == "", rd.HTTP == "", rd.Log == nil
+	deadline := time.After(rd.timeout())
+	tick := time.NewTicker(50 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		if !tcpOK {
+			tcpOK = rd.probeTCP()
+		}
+		if !httpOK {
+			httpOK = rd.probeHTTP()
+		}
+		if tcpOK && httpOK && logOK {
+			return nil
+		}
+		select {
+		case <-exited:
+			select {
+			case <-logged:
+				// the matching line may have been the last one written
+				if tcpOK && httpOK {
+					return nil
+				}
+			default:
+			}
+			return fmt.Errorf("%s exited before becoming ready", bin)
+		case <-abort:
+			return fmt.Errorf("%s stopped before becoming ready", bin)
+		case <-deadline:
+			return fmt.Errorf("%s not ready after %v%s", bin, rd.timeout(), rd.pending(tcpOK, httpOK, logOK))
+		case <-logged:
+			logOK = true
+			logged = nil
+		case <-tick.C:
+		}
+	}
+}
+
+func (rd *Readiness) pending(tcpOK, httpOK, logOK bool) string {
+	msg := ""
+	if !tcpOK {
+		msg += fmt.Sprintf(", no connection to %s", rd.TCP)
+	}
+	if !httpOK {
+		msg += fmt.Sprintf(", no status %d from %s", rd.status(), rd.HTTP)
+	}
+	if !logOK {
+		msg += fmt.Sprintf(", no output matching %q", rd.Log)
+	}
+	return msg
+}
+
+// lineMatcher signals matched once a complete line written to it matches re
+type lineMatcher struct {
+	re      *regexp.Regexp
+	buf     []byte
+	matched chan struct{}
+	once    *sync.Once
+	done    bool
+}
+
+func (m *lineMatcher) Write(p []byte) (int, error) {
+	if m.done {
+		return len(p), nil
+	}
+	m.buf = append(m.buf, p...)
+	for {
+		i := bytes.IndexByte(m.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimRight(m.buf[:i], "\r")
+		m.buf = m.buf[i+1:]
+		if m.re.Match(line) {
+			m.done = true
+			m.buf = nil
+			m.once.Do(func() { close(m.matched) })
+			break
+		}
+	}
+	return len(p), nil
+}
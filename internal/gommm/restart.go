@@ -0,0 +1,152 @@
+package gommm
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RestartPolicy decides whether a binary that exited on its own is started again
+type RestartPolicy string
+
+// Restart policies
+const (
+	// RestartNever leaves an exited binary until the next build or request starts it
+	RestartNever RestartPolicy = "never"
+	// RestartOnFailure restarts a binary that exited with an error
+	RestartOnFailure RestartPolicy = "on-failure"
+	// RestartAlways restarts a binary however it exited
+	RestartAlways RestartPolicy = "always"
+)
+
+// ParseRestartPolicy validates a policy name, the empty string is RestartNever
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	switch p := RestartPolicy(s); p {
+	case "":
+		return RestartNever, nil
+	case RestartNever, RestartOnFailure, RestartAlways:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid restart policy %q, expected never, on-failure or always", s)
+}
+
+// Restart configures restarting a binary that exited on its own
+type Restart struct {
+	Policy RestartPolicy
+	// Backoff before the first restart, doubled with each consecutive one up to MaxBackoff
+	// (default 500ms and 30s)
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// MaxRetries of consecutive restarts, unlimited when 0.
+	// A run lasting longer than MaxBackoff ends a sequence of restarts.
+	MaxRetries int
+	// CrashLoop exits within CrashLoopWindow stop restarting (default 5 within 1m)
+	CrashLoop       int
+	CrashLoopWindow time.Duration
+	// Lines of output reported when restarting stops (default 20)
+	Lines int
+}
+
+func (r *Restart) defaults() {
+	if r.Backoff <= 0 {
+		r.Backoff = 500 * time.Millisecond
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = 30 * time.Second
+	}
+	if r.CrashLoop <= 0 {
+		r.CrashLoop = 5
+	}
+	if r.CrashLoopWindow <= 0 {
+		r.CrashLoopWindow = time.Minute
+	}
+	if r.Lines <= 0 {
+		r.Lines = 20
+	}
+}
+
+// applies reports whether the policy restarts a binary that exited with err
+func (r *Restart) applies(err error) bool {
+	switch r.Policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return err != nil
+	}
+	return false
+}
+
+// restartState tracks the exits of a binary between builds
+type restartState struct {
+	retries int
+	exits   []time.Time
+}
+
+// next records an exit after uptime at now and returns the delay before restarting,
+// or why restarting stops
+func (s *restartState) next(r *Restart, uptime time.Duration, now time.Time) (time.Duration, string) {
+	if uptime > r.MaxBackoff {
+		s.retries = 0
+	}
+	exits := s.exits[:0]
+	for _, exit := range s.exits {
+		if now.Sub(exit) < r.CrashLoopWindow {
+			exits = append(exits, exit)
+		}
+	}
+	s.exits = append(exits, now)
+	if len(s.exits) >= r.CrashLoop {
+		return 0, fmt.Sprintf("crash loop, exited %d times within %s", len(s.exits), r.CrashLoopWindow)
+	}
+	if r.MaxRetries > 0 && s.retries >= r.MaxRetries {
+		return 0, fmt.Sprintf("gave up after %d restarts", s.retries)
+	}
+	delay := r.Backoff
+	for i := 0; i < s.retries && delay < r.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.MaxBackoff {
+		delay = r.MaxBackoff
+	}
+	s.retries++
+	return delay, ""
+}
+
+// tailBuffer keeps the last complete lines written to it
+type tailBuffer struct {
+	mu      sync.Mutex
+	max     int
+	lines   []string
+	partial []byte
+}
+
+func (t *tailBuffer) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partial = append(t.partial, b...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.lines = append(t.lines, string(t.partial[:i]))
+		t.partial = t.partial[i+1:]
+	}
+	if len(t.lines) > t.max {
+		t.lines = append(t.lines[:0], t.lines[len(t.lines)-t.max:]...)
+	}
+	return len(b), nil
+}
+
+// String returns the kept lines including an incomplete last one
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lines := t.lines
+	if len(t.partial) > 0 {
+		lines = append(lines[:len(lines):len(lines)], string(t.partial))
+	}
+	return strings.Join(lines, "\n")
+}
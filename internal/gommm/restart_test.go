@@ -0,0 +1,125 @@
+package gommm_test
+
+import (
+	"bytes"
+	"log"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_ParseRestartPolicy(t *testing.T) {
+	for s, want := range map[string]gommm.RestartPolicy{
+		"":           gommm.RestartNever,
+		"never":      gommm.RestartNever,
+		"on-failure": gommm.RestartOnFailure,
+		"always":     gommm.RestartAlways,
+	} {
+		p, err := gommm.ParseRestartPolicy(s)
+		expect(t, err, nil)
+		expect(t, p, want)
+	}
+	_, err := gommm.ParseRestartPolicy("sometimes")
+	refute(t, err, nil)
+}
+
+// syncBuffer can be read while a runner logs to it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func waitFor(t *testing.T, buff *syncBuffer, s string) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if strings.Contains(buff.String(), s) {
+			return
+		}
+	}
+	t.Fatalf("expected %q in %q", s, buff.String())
+}
+
+// waitExited waits for the binary of runner to exit, the runner itself waits on its command
+func waitExited(t *testing.T, runner gommm.Runner) {
+	r := runner.(interface{ Exited() bool })
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if r.Exited() {
+			return
+		}
+	}
+	t.Fatal("expected the binary to exit")
+}
+
+func Test_Runner_Restart_CrashLoop(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no windows fixture")
+	}
+	bin := filepath.Join("test_fixtures", "crash_output")
+	logs := &syncBuffer{}
+	runner := gommm.NewRunner(bin, nil, log.New(logs, "", 0))
+	runner.SetRestart(&gommm.Restart{
+		Policy:    gommm.RestartOnFailure,
+		Backoff:   10 * time.Millisecond,
+		CrashLoop: 3,
+		Lines:     1,
+	})
+
+	_, err := runner.Run()
+	expect(t, err, nil)
+	waitFor(t, logs, "Not restarting")
+	expect(t, strings.Count(logs.String(), "restarting in"), 2)
+	expect(t, strings.Contains(logs.String(), "crash loop, exited 3 times"), true)
+	expect(t, strings.HasSuffix(logs.String(), "Last output:\npanic: boom\n"), true)
+}
+
+func Test_Runner_Restart_MaxRetries(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no windows fixture")
+	}
+	bin := filepath.Join("test_fixtures", "writing_output")
+	logs := &syncBuffer{}
+	runner := gommm.NewRunner(bin, nil, log.New(logs, "", 0))
+	runner.SetRestart(&gommm.Restart{
+		Policy:     gommm.RestartAlways,
+		Backoff:    10 * time.Millisecond,
+		MaxRetries: 2,
+	})
+
+	_, err := runner.Run()
+	expect(t, err, nil)
+	waitFor(t, logs, "gave up after 2 restarts")
+	expect(t, strings.Contains(logs.String(), "restarting in 10ms"), true)
+	expect(t, strings.Contains(logs.String(), "restarting in 20ms"), true)
+}
+
+func Test_Runner_Restart_Never(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no windows fixture")
+	}
+	bin := filepath.Join("test_fixtures", "crash_output")
+	logs := &syncBuffer{}
+	runner := gommm.NewRunner(bin, nil, log.New(logs, "", 0))
+	runner.SetRestart(&gommm.Restart{Policy: gommm.RestartNever})
+
+	_, err := runner.Run()
+	expect(t, err, nil)
+	waitExited(t, runner)
+	time.Sleep(100 * time.Millisecond)
+	expect(t, strings.Contains(logs.String(), "restarting"), false)
+}
@@ -1,12 +1,15 @@
 package gommm
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
-	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -15,41 +18,161 @@ type Runner interface {
 	Run() (*exec.Cmd, error)
 	Info() (os.FileInfo, error)
 	SetWriter(io.Writer)
+	SetReadiness(*Readiness)
+	SetEnv(env []string)
+	SetEnviron(env []string)
+	Environ() []string
+	SetRestart(*Restart)
+	SetStop(signal os.Signal, timeout time.Duration)
+	SetListeners(files []*os.File)
+	Replace() (*exec.Cmd, error)
 	Kill() error
 }
 
 type runner struct {
-	bin       string
-	args      []string
-	writer    io.Writer
-	command   *exec.Cmd
-	starttime time.Time
-	logger    *log.Logger
+	bin            string
+	args           []string
+	writer         io.Writer
+	ready          *Readiness
+	environ        []string
+	env            []string
+	restart        *Restart
+	restarts       restartState
+	tail           *tailBuffer
+	signal         os.Signal
+	timeout        time.Duration
+	listeners      []*os.File
+	command        *exec.Cmd
+	exited         chan struct{}
+	logged         chan struct{}
+	starting       chan struct{}
+	abort          chan struct{}
+	replaced       *exec.Cmd
+	replacedExited chan struct{}
+	starttime      time.Time
+	logger         *log.Logger
+	mu             sync.Mutex
 }
 
-// NewRunner constructor
-func NewRunner(bin string, logger *log.Logger, args ...string) Runner {
+// NewRunner constructor, env is the whole environment of the binary, gommm's own when nil
+func NewRunner(bin string, env []string, logger *log.Logger, args ...string) Runner {
 	return &runner{
 		bin:       bin,
 		args:      args,
+		environ:   env,
 		writer:    ioutil.Discard,
+		signal:    os.Interrupt,
+		timeout:   3 * time.Second,
 		starttime: time.Now(),
 		logger:    logger,
 	}
 }
 
 func (r *runner) Run() (*exec.Cmd, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.waitStarting()
 	if r.needsRefresh() {
-		r.Kill()
-	}
-	if r.command == nil || r.Exited() {
-		err := r.runBin()
-		if err != nil {
-			log.Print("Error running: ", err)
+		if r.running() && len(r.listeners) > 0 {
+			return r.handoff()
 		}
-		time.Sleep(250 * time.Millisecond)
+		r.kill()
+	}
+	if r.command == nil || r.hasExited() {
+		return r.start()
+	}
+	return r.command, nil
+}
+
+// Replace starts the binary again. With listeners the new binary is started before the running
+// one is stopped, otherwise the running one is stopped first.
+func (r *runner) Replace() (*exec.Cmd, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.waitStarting()
+	if r.running() && len(r.listeners) > 0 {
+		return r.handoff()
+	}
+	if err := r.kill(); err != nil {
 		return r.command, err
 	}
+	return r.start()
+}
+
+func (r *runner) start() (*exec.Cmd, error) {
+	// a new start gets a new sequence of restarts
+	r.restarts = restartState{}
+	err := r.runBin()
+	if err != nil {
+		log.Print("Error running: ", err)
+		return r.command, err
+	}
+	err = r.started()
+	return r.command, err
+}
+
+// started waits for the binary that was just started to become ready. r.mu is released
+// while waiting, kill aborts the wait and other starts wait for it to finish.
+func (r *runner) started() error {
+	command, ready, exited, logged := r.command, r.ready, r.exited, r.logged
+	starting, abort := make(chan struct{}), make(chan struct{})
+	r.starting, r.abort = starting, abort
+	r.mu.Unlock()
+	var err error
+	if ready == nil {
+		select {
+		case <-time.After(250 * time.Millisecond):
+		case <-abort:
+		}
+	} else {
+		err = ready.wait(r.bin, exited, logged, abort)
+	}
+	r.mu.Lock()
+	r.starting, r.abort = nil, nil
+	close(starting)
+	if r.command != command {
+		return fmt.Errorf("%s was stopped before it was ready", r.bin)
+	}
+	return err
+}
+
+// waitStarting waits for a start in progress to finish, r.mu is released while waiting
+func (r *runner) waitStarting() {
+	for r.starting != nil {
+		starting := r.starting
+		r.mu.Unlock()
+		<-starting
+		r.mu.Lock()
+	}
+}
+
+// handoff starts a new binary on the listeners and stops the running one once the new one
+// is ready. The running one keeps serving when the new one fails to start.
+func (r *runner) handoff() (*exec.Cmd, error) {
+	old, oldExited := r.command, r.exited
+	r.restarts = restartState{}
+	if err := r.runBin(); err != nil {
+		r.command, r.exited = old, oldExited
+		log.Print("Error running: ", err)
+		return old, err
+	}
+	// kill stops the running binary as well while the new one is started
+	command := r.command
+	r.replaced, r.replacedExited = old, oldExited
+	err := r.started()
+	r.replaced, r.replacedExited = nil, nil
+	if r.command != command {
+		return nil, err
+	}
+	if err == nil && r.hasExited() {
+		err = fmt.Errorf("%s exited during the handoff", r.bin)
+	}
+	if err != nil {
+		r.stop(r.command, r.exited)
+		r.command, r.exited = old, oldExited
+		return old, err
+	}
+	r.stop(old, oldExited)
 	return r.command, nil
 }
 
@@ -61,69 +184,253 @@ func (r *runner) SetWriter(writer io.Writer) {
 	r.writer = writer
 }
 
+func (r *runner) SetReadiness(ready *Readiness) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ready = ready
+	r.checkReadiness()
+}
+
+// checkReadiness drops the tcp check of an address the listeners accept connections to,
+// it would pass before the binary serves them
+func (r *runner) checkReadiness() {
+	if r.ready == nil || r.ready.TCP == "" || !listensOn(r.listeners, r.ready.TCP) {
+		return
+	}
+	r.logger.Printf("Not checking %s for the readiness of %s, the handed over socket accepts connections before it serves them, check http or log readiness instead\n", r.ready.TCP, r.bin)
+	ready := *r.ready
+	ready.TCP = ""
+	r.ready = &ready
+	if ready.HTTP == "" && ready.Log == nil {
+		r.ready = nil
+	}
+}
+
+// SetEnv adds KEY=VALUE pairs to the environment of the binary, overriding those of the
+// environment passed to NewRunner, taking effect on the next start
+func (r *runner) SetEnv(env []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.env = env
+}
+
+// SetEnviron replaces the environment passed to NewRunner, taking effect on the next start
+func (r *runner) SetEnviron(env []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.environ = env
+}
+
+// Environ returns the environment the binary is started with
+func (r *runner) Environ() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.environment()
+}
+
+func (r *runner) environment() []string {
+	environ := r.environ
+	if environ == nil {
+		environ = os.Environ()
+	}
+	return MergeEnv(environ, r.env)
+}
+
+// SetRestart sets how a binary that exited on its own is restarted, taking effect on the next start
+func (r *runner) SetRestart(restart *Restart) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if restart != nil {
+		restart.defaults()
+	}
+	r.restart = restart
+}
+
+// SetStop sets the signal asking the binary to stop and how long it has before being killed,
+// the defaults are SIGINT and 3s
+func (r *runner) SetStop(signal os.Signal, timeout time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if signal != nil {
+		r.signal = signal
+	}
+	if timeout > 0 {
+		r.timeout = timeout
+	}
+}
+
+// SetListeners sets the listening sockets handed to the binary as file descriptors 3 and up, with
+// LISTEN_FDS and LISTEN_PID set like systemd socket activation, taking effect on the next start.
+// As the sockets stay open a new binary is started before the running one is stopped.
+func (r *runner) SetListeners(files []*os.File) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = files
+	r.checkReadiness()
+}
+
 func (r *runner) Kill() error {
-	if r.command != nil && r.command.Process != nil {
-		done := make(chan error)
-		go func() {
-			r.command.Wait()
-			close(done)
-		}()
-
-		//Trying a "soft" kill first
-		if runtime.GOOS == "windows" {
-			if err := r.command.Process.Kill(); err != nil {
-				return err
-			}
-		} else if err := r.command.Process.Signal(os.Interrupt); err != nil {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.kill()
+}
+
+func (r *runner) kill() error {
+	if r.abort != nil {
+		// the binary being started is stopped without waiting for it to be ready
+		close(r.abort)
+		r.abort = nil
+	}
+	if r.replaced != nil {
+		if err := r.stop(r.replaced, r.replacedExited); err != nil {
+			return err
+		}
+		r.replaced, r.replacedExited = nil, nil
+	}
+	if r.command == nil || r.command.Process == nil {
+		return nil
+	}
+	if err := r.stop(r.command, r.exited); err != nil {
+		return err
+	}
+	r.command = nil
+	return nil
+}
+
+// stop stops command, which closes exited once it is gone, and the processes it started
+func (r *runner) stop(command *exec.Cmd, exited chan struct{}) error {
+	select {
+	case <-exited:
+	default:
+		start := time.Now()
+		//Trying a "soft" kill of the binary and its children first
+		if err := signalProcessGroup(command, r.signal); err != nil {
 			return err
 		}
 
-		//Wait for our process to die before we return or hard kill after 3 sec
+		//Wait for our process to die before we return or hard kill after the timeout
+		signal := SignalName(r.signal)
 		select {
-		case <-time.After(3 * time.Second):
-			if err := r.command.Process.Kill(); err != nil {
+		case <-time.After(r.timeout):
+			if err := killProcessGroup(command); err != nil {
 				log.Println("failed to kill: ", err)
 			}
-		case <-done:
+			<-exited
+			signal = "SIGKILL"
+		case <-exited:
+		}
+		r.logger.Printf("Stopped %s in %s with %s\n", r.bin, time.Since(start).Round(time.Millisecond), signal)
+	}
+	// children that outlive the binary would hold on to its ports
+	if !processGroupExited(command, 0) {
+		killProcessGroup(command)
+		if !processGroupExited(command, time.Second) {
+			r.logger.Printf("Processes started by %s are still running in group %d\n", r.bin, command.Process.Pid)
 		}
-		r.command = nil
 	}
-
 	return nil
 }
 
+// running reports whether the binary was started and has not exited
+func (r *runner) running() bool {
+	return r.command != nil && !r.hasExited()
+}
+
+// Exited reports whether the binary was started and has exited
 func (r *runner) Exited() bool {
-	return r.command != nil && r.command.ProcessState != nil && r.command.ProcessState.Exited()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.hasExited()
+}
+
+func (r *runner) hasExited() bool {
+	if r.command == nil {
+		return false
+	}
+	select {
+	case <-r.exited:
+		return true
+	default:
+		return false
+	}
 }
 
 func (r *runner) runBin() error {
-	r.command = exec.Command(r.bin, r.args...)
-	stdout, err := r.command.StdoutPipe()
-	if err != nil {
-		return err
+	command := exec.Command(r.bin, r.args...)
+	command.Env = r.environment()
+	if len(r.listeners) > 0 {
+		env := command.Env
+		command = listenCommand(r.bin, r.args...)
+		command.ExtraFiles = r.listeners
+		command.Env = MergeEnv(env, []string{fmt.Sprintf("LISTEN_FDS=%d", len(r.listeners))})
 	}
-	stderr, err := r.command.StderrPipe()
-	if err != nil {
-		return err
+	setProcessGroup(command)
+	// Wait returns only once all output has been copied to the writer
+	command.Stdout, command.Stderr = r.writer, r.writer
+	r.logged = nil
+	stdout, stderr := []io.Writer{r.writer}, []io.Writer{r.writer}
+	if r.ready != nil && r.ready.Log != nil {
+		r.logged = make(chan struct{})
+		once := &sync.Once{}
+		stdout = append(stdout, &lineMatcher{re: r.ready.Log, matched: r.logged, once: once})
+		stderr = append(stderr, &lineMatcher{re: r.ready.Log, matched: r.logged, once: once})
+	}
+	// the last lines are reported when restarting stops
+	r.tail = nil
+	if r.restart != nil && r.restart.Policy != RestartNever {
+		r.tail = &tailBuffer{max: r.restart.Lines}
+		stdout, stderr = append(stdout, r.tail), append(stderr, r.tail)
 	}
-	err = r.command.Start()
+	if len(stdout) > 1 {
+		command.Stdout, command.Stderr = io.MultiWriter(stdout...), io.MultiWriter(stderr...)
+	}
+	err := command.Start()
 	if err != nil {
 		return err
 	}
+	exited := make(chan struct{})
+	r.command = command
+	r.exited = exited
 	r.starttime = time.Now()
-	go io.Copy(r.writer, stdout)
-	go io.Copy(r.writer, stderr)
 	go func() {
-		err = r.command.Wait()
+		err := command.Wait()
 		if err != nil {
 			r.logger.Printf("Error running %s %v err:%v\n", r.bin, r.args, err)
 		}
-		stdout.Close()
-		stderr.Close()
+		close(exited)
+		r.exitedOnItsOwn(command, err)
 	}()
 	return nil
 }
 
+// exitedOnItsOwn restarts command according to the restart policy, unless it was killed
+// or replaced in the meantime
+func (r *runner) exitedOnItsOwn(command *exec.Cmd, err error) {
+	r.mu.Lock()
+	r.waitStarting()
+	if r.command != command || r.restart == nil || !r.restart.applies(err) {
+		r.mu.Unlock()
+		return
+	}
+	delay, stop := r.restarts.next(r.restart, time.Since(r.starttime), time.Now())
+	if stop != "" {
+		r.logger.Printf("Not restarting %s, %s. Last output:\n%s\n", r.bin, stop, r.tail)
+		r.mu.Unlock()
+		return
+	}
+	r.logger.Printf("%s exited, restarting in %s\n", r.bin, delay)
+	r.mu.Unlock()
+	time.Sleep(delay)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.command != command {
+		return
+	}
+	if err := r.runBin(); err != nil {
+		r.logger.Printf("Error restarting %s err:%v\n", r.bin, err)
+	}
+}
+
 func (r *runner) needsRefresh() bool {
 	info, err := r.Info()
 	if err != nil {
@@ -132,3 +439,54 @@ func (r *runner) needsRefresh() bool {
 		return info.ModTime().After(r.starttime)
 	}
 }
+
+// MergeEnv merges lists of KEY=VALUE pairs, a later value of a key replacing an earlier one in its place
+func MergeEnv(envs ...[]string) []string {
+	var merged []string
+	index := make(map[string]int)
+	for _, env := range envs {
+		for _, kv := range env {
+			key := kv
+			if i := strings.Index(kv, "="); i >= 0 {
+				key = kv[:i]
+			}
+			if i, ok := index[key]; ok {
+				merged[i] = kv
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, kv)
+		}
+	}
+	return merged
+}
+
+// DiffEnv compares two lists of KEY=VALUE pairs, returning the sorted keys added, removed and changed
+func DiffEnv(old, new []string) (added, removed, changed []string) {
+	values := func(env []string) map[string]string {
+		m := make(map[string]string)
+		for _, kv := range env {
+			if i := strings.Index(kv, "="); i >= 0 {
+				m[kv[:i]] = kv[i+1:]
+			}
+		}
+		return m
+	}
+	before, after := values(old), values(new)
+	for key, val := range after {
+		if prev, ok := before[key]; !ok {
+			added = append(added, key)
+		} else if prev != val {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
@@ -2,10 +2,17 @@ package gommm_test
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -19,7 +26,7 @@ func Test_NewRunner(t *testing.T) {
 	}
 	bin := filepath.Join("test_fixtures", filename)
 
-	runner := gommm.NewRunner(bin, log.New(os.Stdout, "[gommm] ", 0))
+	runner := gommm.NewRunner(bin, nil, log.New(os.Stdout, "[gommm] ", 0))
 
 	fi, _ := runner.Info()
 	expect(t, fi.Name(), filename)
@@ -30,15 +37,44 @@ func Test_Runner_Run(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		bin += ".bat"
 	}
-	runner := gommm.NewRunner(bin, log.New(os.Stdout, "[gommm] ", 0))
+	runner := gommm.NewRunner(bin, nil, log.New(os.Stdout, "[gommm] ", 0))
 
 	cmd, err := runner.Run()
 	expect(t, err, nil)
 	expect(t, cmd.Process == nil, false)
 }
 
-// func Test_Runner_SettingEnvironment(t *testing.T) {
-// }
+func Test_Runner_SettingEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no windows fixture")
+	}
+	bin := filepath.Join("test_fixtures", "print_env")
+	env := []string{"PATH=" + os.Getenv("PATH"), "GOMMM_TEST_VAR=from env file", "OTHER=1"}
+	runner := gommm.NewRunner(bin, env, log.New(os.Stdout, "[gommm] ", 0))
+	buff := &bytes.Buffer{}
+	runner.SetWriter(buff)
+	runner.SetEnv([]string{"GOMMM_TEST_VAR=from runner"})
+	expect(t, strings.Join(runner.Environ(), " "), "PATH="+os.Getenv("PATH")+" GOMMM_TEST_VAR=from runner OTHER=1")
+
+	_, err := runner.Run()
+	expect(t, err, nil)
+	waitExited(t, runner)
+	expect(t, buff.String(), "from runner\n")
+	// gommm's own environment is left alone
+	expect(t, os.Getenv("GOMMM_TEST_VAR"), "")
+}
+
+func Test_DiffEnv(t *testing.T) {
+	added, removed, changed := gommm.DiffEnv([]string{"A=1", "B=2", "C=3"}, []string{"C=4", "A=1", "D=5", "E="})
+	expect(t, strings.Join(added, " "), "D E")
+	expect(t, strings.Join(removed, " "), "B")
+	expect(t, strings.Join(changed, " "), "C")
+}
+
+func Test_MergeEnv(t *testing.T) {
+	merged := gommm.MergeEnv([]string{"A=1", "B=2"}, []string{"C=3", "A=4"}, nil)
+	expect(t, strings.Join(merged, " "), "A=4 B=2 C=3")
+}
 
 func Test_Runner_Kill(t *testing.T) {
 	bin := filepath.Join("test_fixtures", "writing_output")
@@ -46,7 +82,7 @@ func Test_Runner_Kill(t *testing.T) {
 		bin += ".bat"
 	}
 
-	runner := gommm.NewRunner(bin, log.New(os.Stdout, "[gommm] ", 0))
+	runner := gommm.NewRunner(bin, nil, log.New(os.Stdout, "[gommm] ", 0))
 
 	cmd1, err := runner.Run()
 	expect(t, err, nil)
@@ -78,12 +114,12 @@ func Test_Runner_SetWriter(t *testing.T) {
 		bin += ".bat"
 	}
 
-	runner := gommm.NewRunner(bin, log.New(os.Stdout, "[gommm] ", 0))
+	runner := gommm.NewRunner(bin, nil, log.New(os.Stdout, "[gommm] ", 0))
 	runner.SetWriter(buff)
 
-	cmd, err := runner.Run()
-	cmd.Wait()
+	_, err := runner.Run()
 	expect(t, err, nil)
+	waitExited(t, runner)
 
 	if runtime.GOOS == "windows" {
 		expect(t, buff.String(), "Hello world\r\n")
@@ -91,3 +127,245 @@ func Test_Runner_SetWriter(t *testing.T) {
 		expect(t, buff.String(), "Hello world\n")
 	}
 }
+
+func Test_Runner_Readiness_Log(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no windows fixture")
+	}
+	bin := filepath.Join("test_fixtures", "ready_output")
+	runner := gommm.NewRunner(bin, nil, log.New(os.Stdout, "[gommm] ", 0))
+	runner.SetReadiness(&gommm.Readiness{
+		Log:     regexp.MustCompile("^Listening"),
+		Timeout: 5 * time.Second,
+	})
+
+	start := time.Now()
+	_, err := runner.Run()
+	expect(t, err, nil)
+	expect(t, time.Since(start) < time.Second, true)
+	runner.Kill()
+}
+
+func Test_Runner_Readiness_TCP(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no windows fixture")
+	}
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	bin := filepath.Join("test_fixtures", "ready_output")
+	runner := gommm.NewRunner(bin, nil, log.New(os.Stdout, "[gommm] ", 0))
+	runner.SetReadiness(&gommm.Readiness{
+		TCP:     addr,
+		Timeout: 200 * time.Millisecond,
+	})
+
+	_, err = runner.Run()
+	refute(t, err, nil)
+	runner.Kill()
+
+	// the process exits before anything listens
+	runner.SetReadiness(&gommm.Readiness{
+		TCP:     addr,
+		Timeout: 5 * time.Second,
+	})
+	_, err = runner.Run()
+	refute(t, err, nil)
+	expect(t, strings.Contains(err.Error(), "exited before becoming ready"), true)
+}
+
+func Test_Runner_Readiness_Kill(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no windows fixture")
+	}
+	bin := filepath.Join("test_fixtures", "ready_output")
+	runner := gommm.NewRunner(bin, nil, log.New(os.Stdout, "[gommm] ", 0))
+	runner.SetReadiness(&gommm.Readiness{
+		Log:     regexp.MustCompile("^never"),
+		Timeout: 10 * time.Second,
+	})
+
+	start := time.Now()
+	started := make(chan error)
+	go func() {
+		_, err := runner.Run()
+		started <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	// the runner is not locked while waiting for the binary to become ready
+	runner.SetEnviron(nil)
+	expect(t, len(runner.Environ()) > 0, true)
+	expect(t, runner.Kill(), nil)
+	select {
+	case err := <-started:
+		refute(t, err, nil)
+		expect(t, strings.Contains(err.Error(), "stopped before it was ready"), true)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run still waiting after Kill")
+	}
+	expect(t, time.Since(start) < 5*time.Second, true)
+}
+
+func Test_Runner_Kill_ProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no process groups on windows")
+	}
+	dir, err := ioutil.TempDir("", "gommm_runner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidfile := filepath.Join(dir, "pid")
+
+	bin := filepath.Join("test_fixtures", "spawn_children")
+	logs := &syncBuffer{}
+	runner := gommm.NewRunner(bin, nil, log.New(logs, "[gommm] ", 0))
+	runner.SetEnv([]string{"GOMMM_TEST_PIDFILE=" + pidfile})
+	runner.SetStop(nil, 200*time.Millisecond)
+	_, err = runner.Run()
+	expect(t, err, nil)
+
+	var pid int
+	for i := 0; i < 100 && pid == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		b, _ := ioutil.ReadFile(pidfile)
+		pid, _ = strconv.Atoi(strings.TrimSpace(string(b)))
+	}
+	refute(t, pid, 0)
+
+	expect(t, runner.Kill(), nil)
+	expect(t, strings.Contains(logs.String(), "with SIGKILL"), true)
+	if runtime.GOOS == "linux" {
+		// the child is gone, or a zombie waiting for init, once the process group is killed
+		stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		expect(t, err != nil || strings.Contains(string(stat), ") Z "), true)
+	}
+}
+
+func Test_Runner_Kill_StopSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no signals on windows")
+	}
+	bin := filepath.Join("test_fixtures", "graceful_stop")
+	logs := &syncBuffer{}
+	runner := gommm.NewRunner(bin, nil, log.New(logs, "[gommm] ", 0))
+	buff := &syncBuffer{}
+	runner.SetWriter(buff)
+	runner.SetStop(syscall.SIGTERM, 5*time.Second)
+	runner.SetReadiness(&gommm.Readiness{Log: regexp.MustCompile("^started"), Timeout: 5 * time.Second})
+
+	_, err := runner.Run()
+	expect(t, err, nil)
+	expect(t, runner.Kill(), nil)
+	expect(t, strings.HasSuffix(buff.String(), "stopping\n"), true)
+	expect(t, strings.Contains(logs.String(), "with SIGTERM"), true)
+}
+
+func Test_Runner_SocketHandoff(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no socket handoff on windows")
+	}
+	files, err := gommm.Listen([]string{"localhost:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files[0].Close()
+	ln, err := net.FileListener(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	bin := filepath.Join("test_fixtures", "socket_handoff")
+	runner := gommm.NewRunner(bin, nil, log.New(os.Stdout, "[gommm] ", 0))
+	buff := &syncBuffer{}
+	runner.SetWriter(buff)
+	runner.SetListeners(files)
+	runner.SetReadiness(&gommm.Readiness{Log: regexp.MustCompile("^started"), Timeout: 5 * time.Second})
+	defer runner.Kill()
+
+	_, err = runner.Run()
+	expect(t, err, nil)
+	time.Sleep(10 * time.Millisecond)
+	os.Chtimes(bin, time.Now(), time.Now())
+	_, err = runner.Run()
+	expect(t, err, nil)
+
+	// the new binary was started before the old one was stopped
+	lines := strings.Split(strings.TrimSpace(buff.String()), "\n")
+	expect(t, len(lines), 3)
+	old, new := strings.Fields(lines[0]), strings.Fields(lines[1])
+	expect(t, strings.Join(old[2:], " "), old[1]+" 1 yes")
+	expect(t, strings.Join(new[2:], " "), new[1]+" 1 yes")
+	expect(t, lines[2], "stopped "+old[1])
+
+	// the socket stays open throughout
+	conn, err := net.Dial("tcp", addr)
+	expect(t, err, nil)
+	conn.Close()
+}
+
+func Test_Runner_SocketHandoff_SlowStart(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no socket handoff on windows")
+	}
+	files, err := gommm.Listen([]string{"localhost:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files[0].Close()
+	ln, err := net.FileListener(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	bin := filepath.Join("test_fixtures", "slow_handoff")
+	logs := &syncBuffer{}
+	runner := gommm.NewRunner(bin, nil, log.New(logs, "", 0))
+	runner.SetListeners(files)
+	// the socket accepts connections right away, only the log tells the binary serves them
+	runner.SetReadiness(&gommm.Readiness{TCP: addr, Log: regexp.MustCompile("^serving"), Timeout: 5 * time.Second})
+	expect(t, strings.Contains(logs.String(), "Not checking "+addr), true)
+	defer runner.Kill()
+
+	refused := make(chan error, 1)
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			conn, err := net.DialTimeout("tcp", addr, time.Second)
+			if err != nil {
+				refused <- err
+				return
+			}
+			conn.Close()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		_, err = runner.Run()
+		expect(t, err, nil)
+		expect(t, time.Since(start) >= 500*time.Millisecond, true)
+		time.Sleep(10 * time.Millisecond)
+		os.Chtimes(bin, time.Now(), time.Now())
+	}
+	close(stop)
+	select {
+	case err := <-refused:
+		t.Fatalf("connection refused during the handoff err:%v", err)
+	default:
+	}
+}
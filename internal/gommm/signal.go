@@ -0,0 +1,47 @@
+package gommm
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// stopSignals are the signals that can ask a binary to stop
+var stopSignals = []struct {
+	name   string
+	signal syscall.Signal
+}{
+	{"SIGTERM", syscall.SIGTERM},
+	{"SIGINT", syscall.SIGINT},
+	{"SIGQUIT", syscall.SIGQUIT},
+	{"SIGHUP", syscall.SIGHUP},
+}
+
+// ParseSignal returns the stop signal named s, with or without the SIG prefix and in any case.
+// The empty string is SIGINT.
+func ParseSignal(s string) (os.Signal, error) {
+	if s == "" {
+		return os.Interrupt, nil
+	}
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	for _, sig := range stopSignals {
+		if sig.name == name {
+			return sig.signal, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid stop signal %q, expected SIGTERM, SIGINT, SIGQUIT or SIGHUP", s)
+}
+
+// SignalName returns the name of a stop signal, e.g. SIGTERM
+func SignalName(signal os.Signal) string {
+	for _, sig := range stopSignals {
+		if sig.signal == signal {
+			return sig.name
+		}
+	}
+	return signal.String()
+}
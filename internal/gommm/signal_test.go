@@ -0,0 +1,30 @@
+package gommm_test
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_ParseSignal(t *testing.T) {
+	for s, want := range map[string]os.Signal{
+		"":        os.Interrupt,
+		"SIGTERM": syscall.SIGTERM,
+		"term":    syscall.SIGTERM,
+		"SigQuit": syscall.SIGQUIT,
+		"HUP":     syscall.SIGHUP,
+	} {
+		sig, err := gommm.ParseSignal(s)
+		expect(t, err, nil)
+		expect(t, sig, want)
+	}
+	_, err := gommm.ParseSignal("SIGKILL")
+	refute(t, err, nil)
+}
+
+func Test_SignalName(t *testing.T) {
+	expect(t, gommm.SignalName(os.Interrupt), "SIGINT")
+	expect(t, gommm.SignalName(syscall.SIGTERM), "SIGTERM")
+}
@@ -0,0 +1,114 @@
+package gommm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// Step is a named shell command run before or after go build
+type Step struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	// Dir to run the command in, relative to the build directory
+	Dir string `json:"dir"`
+	// Env holds KEY=VALUE pairs added to the environment of the command
+	Env []string `json:"env"`
+	// Timeout in seconds, no timeout when 0
+	Timeout int `json:"timeout"`
+	// Continue with the build when the step fails instead of failing it
+	Continue bool `json:"continue"`
+	// args are run instead of Command without a shell
+	args []string
+}
+
+// StepResult is the outcome of a Step in the last build
+type StepResult struct {
+	Name     string
+	Output   string
+	Duration time.Duration
+	Err      error
+}
+
+// Pipeline holds the steps run before go build and after it succeeded,
+// in the order they are run
+type Pipeline struct {
+	PreBuild  []Step `json:"pre_build"`
+	PostBuild []Step `json:"post_build"`
+}
+
+// LoadPipeline reads a Pipeline from a json file
+func LoadPipeline(path string) (*Pipeline, error) {
+	fr, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read pipeline file %s", path)
+	}
+	defer fr.Close()
+	pipeline := new(Pipeline)
+	if err := json.NewDecoder(fr).Decode(pipeline); err != nil {
+		return nil, fmt.Errorf("Unable to parse pipeline file %s err:%v", path, err)
+	}
+	for _, steps := range [][]Step{pipeline.PreBuild, pipeline.PostBuild} {
+		for i := range steps {
+			if steps[i].Command == "" {
+				return nil, fmt.Errorf("Step %d of pipeline file %s has no command", i+1, path)
+			}
+			if steps[i].Name == "" {
+				steps[i].Name = steps[i].Command
+			}
+		}
+	}
+	return pipeline, nil
+}
+
+// run runs the step in dir with the environment env, the variables of the step added to it.
+// A cancelled ctx aborts the step, leaving ctx.Err() in the result.
+func (s Step) run(ctx context.Context, dir string, env []string) StepResult {
+	if !filepath.IsAbs(s.Dir) {
+		dir = filepath.Join(dir, s.Dir)
+	} else {
+		dir = s.Dir
+	}
+	stepCtx := ctx
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		stepCtx, cancel = context.WithTimeout(ctx, time.Duration(s.Timeout)*time.Second)
+		defer cancel()
+	}
+	cmd := shellCommand(s.Command)
+	if len(s.args) > 0 {
+		cmd = exec.Command(s.args[0], s.args[1:]...)
+	}
+	cmd.Dir = dir
+	cmd.Env = MergeEnv(env, s.Env)
+	start := time.Now()
+	output, err := runContext(stepCtx, cmd)
+	result := StepResult{Name: s.Name, Output: string(output), Duration: time.Since(start), Err: err}
+	if ctx.Err() != nil {
+		result.Err = ctx.Err()
+	} else if stepCtx.Err() != nil {
+		result.Err = fmt.Errorf("timed out after %ds", s.Timeout)
+	}
+	return result
+}
+
+// RunCommand runs command with the shell in dir with env, gommm's own environment when nil,
+// returning its combined output
+func RunCommand(ctx context.Context, dir string, env []string, command string) ([]byte, error) {
+	cmd := shellCommand(command)
+	cmd.Dir = dir
+	cmd.Env = env
+	return runContext(ctx, cmd)
+}
+
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
@@ -0,0 +1,43 @@
+package gommm_test
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_LoadPipeline(t *testing.T) {
+	pipeline, err := gommm.LoadPipeline("test_fixtures/pipeline.json")
+	expect(t, err, nil)
+	expect(t, len(pipeline.PreBuild), 2)
+	expect(t, pipeline.PreBuild[0].Name, "generate")
+	expect(t, pipeline.PreBuild[0].Timeout, 60)
+	// steps are named after their command by default
+	expect(t, pipeline.PreBuild[1].Name, "templ generate")
+	expect(t, pipeline.PreBuild[1].Dir, "web")
+	expect(t, pipeline.PreBuild[1].Env[0], "TEMPL_LOG=debug")
+	expect(t, pipeline.PreBuild[1].Continue, true)
+	expect(t, len(pipeline.PostBuild), 1)
+	expect(t, pipeline.PostBuild[0].Name, "sign")
+}
+
+func Test_LoadPipeline_WithMalformedFile(t *testing.T) {
+	_, err := gommm.LoadPipeline("test_fixtures/bad_config.json")
+	refute(t, err, nil)
+}
+
+func Test_RunCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no sh on windows")
+	}
+	output, err := gommm.RunCommand(context.Background(), "test_fixtures", nil, "ls config.json && exit 3")
+	expect(t, string(output), "config.json\n")
+	refute(t, err, nil)
+
+	output, err = gommm.RunCommand(context.Background(), "test_fixtures", gommm.MergeEnv(os.Environ(), []string{"V=from env file"}), "echo $V")
+	expect(t, err, nil)
+	expect(t, string(output), "from env file\n")
+}
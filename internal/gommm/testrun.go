@@ -0,0 +1,95 @@
+package gommm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TestResult is the outcome of the tests of a package
+type TestResult struct {
+	Package string
+	// Action is pass, fail or skip, the latter for packages without tests
+	Action  string
+	Elapsed time.Duration
+	// Failed names the failing tests
+	Failed []string
+	// Output of the package, including its build errors
+	Output string
+}
+
+// testEvent is a line of go test -json output, see go doc test2json
+type testEvent struct {
+	Action     string
+	Package    string
+	ImportPath string
+	Test       string
+	Elapsed    float64
+	Output     string
+}
+
+// RunTests runs go test -json with args on pkgs in dir with env, gommm's own environment when nil.
+// Results are sorted by package. Output not attributed to a package, such as build errors of older go versions, is returned separately.
+func RunTests(ctx context.Context, dir string, env []string, args []string, pkgs []string) ([]TestResult, string, error) {
+	cmd := exec.Command("go", append(append([]string{"test", "-json"}, args...), pkgs...)...)
+	cmd.Dir = dir
+	cmd.Env = env
+	output, err := runContext(ctx, cmd)
+	if ctx.Err() != nil {
+		return nil, "", ctx.Err()
+	}
+	results := make(map[string]*TestResult)
+	outputs := make(map[string]*strings.Builder)
+	result := func(pkg string) *TestResult {
+		if results[pkg] == nil {
+			results[pkg] = &TestResult{Package: pkg}
+			outputs[pkg] = &strings.Builder{}
+		}
+		return results[pkg]
+	}
+	var other strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var ev testEvent
+		if len(line) == 0 || line[0] != '{' || json.Unmarshal(line, &ev) != nil {
+			other.Write(line)
+			other.WriteByte('\n')
+			continue
+		}
+		switch {
+		case ev.Action == "build-output":
+			result(stripVariant(ev.ImportPath))
+			outputs[stripVariant(ev.ImportPath)].WriteString(ev.Output)
+		case ev.Package == "":
+		case ev.Action == "output":
+			result(ev.Package)
+			outputs[ev.Package].WriteString(ev.Output)
+		case ev.Action == "fail" && ev.Test != "":
+			r := result(ev.Package)
+			r.Failed = append(r.Failed, ev.Test)
+		case ev.Test == "" && (ev.Action == "pass" || ev.Action == "fail" || ev.Action == "skip"):
+			r := result(ev.Package)
+			r.Action = ev.Action
+			r.Elapsed = time.Duration(ev.Elapsed * float64(time.Second))
+		}
+	}
+	list := make([]TestResult, 0, len(results))
+	for pkg, r := range results {
+		// build-output of packages without a final action, e.g. dependencies of tested packages
+		if r.Action == "" {
+			other.WriteString(outputs[pkg].String())
+			continue
+		}
+		r.Output = outputs[pkg].String()
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Package < list[j].Package })
+	return list, other.String(), err
+}
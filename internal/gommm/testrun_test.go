@@ -0,0 +1,43 @@
+package gommm_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func Test_RunTests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		"go.mod":               "module tests\n",
+		"good/good.go":         "package good\n",
+		"good/good_test.go":    "package good\n\nimport \"testing\"\n\nfunc TestGood(t *testing.T) {}\n",
+		"bad/bad.go":           "package bad\n",
+		"bad/bad_test.go":      "package bad\n\nimport \"testing\"\n\nfunc TestBad(t *testing.T) { t.Fatal(\"boom\") }\n\nfunc TestFine(t *testing.T) {}\n",
+		"broken/broken.go":     "package broken\n",
+		"broken/bad_test.go":   "package broken\n\nimport \"testing\"\n\nfunc TestBroken(t *testing.T) { undefined() }\n",
+		"notests/notests.go":   "package notests\n",
+		"filtered/f_test.go":   "package filtered\n\nimport \"testing\"\n\nfunc TestOther(t *testing.T) { t.Fatal(\"not run\") }\n",
+		"filtered/filtered.go": "package filtered\n",
+	})
+
+	results, other, err := gommm.RunTests(context.Background(), dir, nil, []string{"-run", "Good|Bad|Broken|Fine"}, []string{"./..."})
+	refute(t, err, nil)
+	actions := make([]string, 0, len(results))
+	for _, r := range results {
+		actions = append(actions, r.Package+"="+r.Action)
+	}
+	expect(t, strings.Join(actions, " "), "tests/bad=fail tests/broken=fail tests/filtered=pass tests/good=pass tests/notests=skip")
+	expect(t, strings.Join(results[0].Failed, " "), "TestBad")
+	expect(t, strings.Contains(results[0].Output, "boom"), true)
+	// older go versions do not attribute build errors to the package
+	expect(t, strings.Contains(results[1].Output+other, "undefined"), true)
+}
@@ -0,0 +1,294 @@
+package gommm
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Watcher reports changed files below a directory tree
+type Watcher interface {
+	Watch(cb WatchCallback) error
+	// Add watches another directory tree with the same filter, or a single file
+	// the filter reports, e.g. one of Files
+	Add(dir string) error
+	Close() error
+}
+
+// WatchCallback is called with the path of each changed file
+type WatchCallback func(path string)
+
+// Filter selects the files below a watched directory whose changes are reported.
+// Patterns are doublestar globs relative to the watched directory, see MatchGlob.
+// Hidden files are only reported when the last element of an Include pattern starts with a dot,
+// .git directories never are.
+type Filter struct {
+	// Include patterns a file has to match one of
+	Include []string
+	// Exclude patterns of files and directories to ignore
+	Exclude []string
+	// ExcludeDir directories to ignore, relative to the watched directory or to the
+	// working directory, e.g. ./vendor or src/vendor/ when watching src
+	ExcludeDir []string
+	// GitIgnore ignores what .gitignore and .ignore files in the tree ignore
+	GitIgnore bool
+	// Graphs when set report only .go files one of the builds depends on,
+	// along with the go.mod, go.sum and embedded files of them
+	Graphs []*Graph
+	// Files are reported whatever the patterns, even when hidden, e.g. env files
+	Files []string
+}
+
+// NewWatcher constructor
+// Uses inotify where available and falls back to polling the tree otherwise.
+func NewWatcher(dir string, filter Filter, poll bool, logger *log.Logger) (Watcher, error) {
+	wf := newWatchFilter(dir, filter)
+	if !poll {
+		w, err := newInotifyWatcher(dir, wf, logger)
+		if err == nil {
+			return w, nil
+		}
+		logger.Printf("inotify watcher unavailable, falling back to polling err:%v\n", err)
+	}
+	return newPollWatcher(dir, wf, 500*time.Millisecond), nil
+}
+
+// watchFilter applies a Filter, it is shared by all watcher backends
+type watchFilter struct {
+	dir     string
+	filter  Filter
+	mu      sync.Mutex
+	ignores map[string][]ignoreRule
+}
+
+func newWatchFilter(dir string, filter Filter) *watchFilter {
+	// directories are matched as globs against clean paths
+	dirs := make([]string, 0, len(filter.ExcludeDir))
+	for _, x := range filter.ExcludeDir {
+		dirs = append(dirs, filepath.ToSlash(filepath.Clean(x)))
+	}
+	filter.ExcludeDir = dirs
+	return &watchFilter{dir: dir, filter: filter, ignores: make(map[string][]ignoreRule)}
+}
+
+// rel returns path relative to the watched directory, slash separated
+func (f *watchFilter) rel(path string) string {
+	return relPath(f.dir, path)
+}
+
+func (f *watchFilter) excluded(rel string) bool {
+	for _, x := range f.filter.Exclude {
+		if MatchGlob(x, rel) {
+			return true
+		}
+	}
+	for _, x := range f.filter.ExcludeDir {
+		if MatchGlob(x, rel) {
+			return true
+		}
+		if !strings.HasPrefix(rel, "../") && MatchGlob(x, filepath.ToSlash(filepath.Join(f.dir, rel))) {
+			return true
+		}
+	}
+	return false
+}
+
+// ignored applies the ignore files of every directory from the watched one down to path,
+// deeper files take precedence
+func (f *watchFilter) ignored(rel string, isDir bool) bool {
+	// ignore files only apply within the watched directory
+	if !f.filter.GitIgnore || strings.HasPrefix(rel, "../") {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	ignored := false
+	for i := range parts {
+		dir := filepath.Join(append([]string{f.dir}, parts[:i]...)...)
+		sub := strings.Join(parts[i:], "/")
+		for _, rule := range f.ignoreRules(dir) {
+			if rule.match(sub, isDir) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+func (f *watchFilter) ignoreRules(dir string) []ignoreRule {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rules, ok := f.ignores[dir]
+	if !ok {
+		rules = readIgnore(dir)
+		f.ignores[dir] = rules
+	}
+	return rules
+}
+
+// notice has to be called with every changed path before it is matched,
+// so edited ignore files are read again
+func (f *watchFilter) notice(path string) {
+	if f.filter.GitIgnore && isIgnoreFile(path) {
+		f.mu.Lock()
+		delete(f.ignores, filepath.Dir(path))
+		f.mu.Unlock()
+	}
+}
+
+// skipDir reports whether the directory should not be descended into
+func (f *watchFilter) skipDir(path string) bool {
+	if filepath.Base(path) == ".git" {
+		return true
+	}
+	rel := f.rel(path)
+	return f.excluded(rel) || f.ignored(rel, true)
+}
+
+// match reports whether a change to the file should be reported
+func (f *watchFilter) match(path string) bool {
+	if len(f.filter.Files) > 0 {
+		abs, _ := filepath.Abs(path)
+		for _, file := range f.filter.Files {
+			if file == path || file == abs {
+				return true
+			}
+		}
+	}
+	rel := f.rel(path)
+	if f.excluded(rel) || f.ignored(rel, false) {
+		return false
+	}
+	hidden := filepath.Base(path)[0] == '.'
+	if !hidden && len(f.filter.Graphs) > 0 {
+		for _, graph := range f.filter.Graphs {
+			if graph.Contains(path) {
+				return true
+			}
+		}
+		if filepath.Ext(path) == ".go" {
+			return false
+		}
+	}
+	for _, x := range f.filter.Include {
+		// hidden files only match patterns naming them, e.g. .env or **/.env.*
+		if hidden && !strings.HasPrefix(x[strings.LastIndex(x, "/")+1:], ".") {
+			continue
+		}
+		if MatchGlob(x, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// pollWatcher walks the tree at a fixed interval comparing modification times
+type pollWatcher struct {
+	filter   *watchFilter
+	interval time.Duration
+	mu       sync.Mutex
+	roots    []string
+	added    []string
+	mtimes   map[string]time.Time
+	done     chan struct{}
+}
+
+func newPollWatcher(dir string, filter *watchFilter, interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		filter:   filter,
+		interval: interval,
+		added:    []string{dir},
+		done:     make(chan struct{}),
+	}
+	w.mtimes = w.scan(nil)
+	return w
+}
+
+// scan walks the tree reporting files whose modification time differs from the last scan,
+// as well as new and removed files. Files of roots added since the last scan are not reported.
+func (w *pollWatcher) scan(cb WatchCallback) map[string]time.Time {
+	mtimes := make(map[string]time.Time, len(w.mtimes))
+	report := func(path string) {
+		if cb == nil {
+			return
+		}
+		w.filter.notice(path)
+		if w.filter.match(path) {
+			cb(path)
+		}
+	}
+	w.mu.Lock()
+	roots, added := w.roots, w.added
+	w.roots, w.added = append(w.roots, w.added...), nil
+	w.mu.Unlock()
+	walk := func(root string, fresh bool) {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if path != root && w.filter.skipDir(path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			// roots may overlap
+			if _, ok := mtimes[path]; ok {
+				return nil
+			}
+			mtimes[path] = info.ModTime()
+			if last, ok := w.mtimes[path]; !fresh && (!ok || !last.Equal(info.ModTime())) {
+				report(path)
+			}
+			return nil
+		})
+	}
+	for _, root := range roots {
+		walk(root, false)
+	}
+	for _, root := range added {
+		walk(root, true)
+	}
+	for path := range w.mtimes {
+		if _, ok := mtimes[path]; !ok {
+			report(path)
+		}
+	}
+	return mtimes
+}
+
+func (w *pollWatcher) Watch(cb WatchCallback) error {
+	for {
+		select {
+		case <-w.done:
+			return nil
+		case <-time.After(w.interval):
+		}
+		w.mtimes = w.scan(cb)
+	}
+}
+
+// Add takes effect with the next scan, a file is scanned on its own
+func (w *pollWatcher) Add(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, roots := range [][]string{w.roots, w.added} {
+		for _, root := range roots {
+			if root == dir {
+				return nil
+			}
+		}
+	}
+	w.added = append(w.added, dir)
+	return nil
+}
+
+func (w *pollWatcher) Close() error {
+	close(w.done)
+	return nil
+}
@@ -0,0 +1,186 @@
+//go:build linux
+// +build linux
+
+package gommm
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW
+
+// inotifyWatcher subscribes to every directory of the tree and follows newly created ones
+type inotifyWatcher struct {
+	filter *watchFilter
+	fd     int
+	file   *os.File
+	mu     sync.Mutex
+	paths  map[int]string
+	// files holds the only files reported of the directories subscribed to for single files
+	files  map[int]map[string]bool
+	logger *log.Logger
+}
+
+func newInotifyWatcher(dir string, filter *watchFilter, logger *log.Logger) (Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &inotifyWatcher{
+		filter: filter,
+		fd:     fd,
+		// a non-blocking fd is registered with the runtime poller, so Close unblocks Read
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		paths:  make(map[int]string),
+		files:  make(map[int]map[string]bool),
+		logger: logger,
+	}
+	if err := w.addTree(dir, nil); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// addTree subscribes to dir and all directories below it.
+// When cb is not nil files already present are reported, as they may have
+// been written before the subscription was in place.
+func (w *inotifyWatcher) addTree(dir string, cb WatchCallback) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// the directory may have gone again already
+			if path == dir && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			if cb != nil && w.filter.match(path) {
+				cb(path)
+			}
+			return nil
+		}
+		// dir itself has been checked by the caller
+		if path != dir && w.filter.skipDir(path) {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			if err == syscall.ENOSPC {
+				return fmt.Errorf("inotify watch limit reached adding %s (see fs.inotify.max_user_watches)", path)
+			}
+			if err == syscall.ENOENT {
+				return nil
+			}
+			return fmt.Errorf("inotify add watch %s err:%v", path, err)
+		}
+		w.mu.Lock()
+		w.paths[wd] = path
+		delete(w.files, wd)
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+// addFile subscribes to the directory of path, reporting path only
+func (w *inotifyWatcher) addFile(path string) error {
+	dir := filepath.Dir(path)
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return fmt.Errorf("inotify add watch %s err:%v", dir, err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.paths[wd]; ok && w.files[wd] == nil {
+		// the whole directory is subscribed to already
+		return nil
+	}
+	w.paths[wd] = dir
+	if w.files[wd] == nil {
+		w.files[wd] = make(map[string]bool)
+	}
+	w.files[wd][path] = true
+	return nil
+}
+
+func (w *inotifyWatcher) Watch(cb WatchCallback) error {
+	buf := make([]byte, (syscall.SizeofInotifyEvent+syscall.NAME_MAX+1)*64)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		offset := 0
+		for offset+syscall.SizeofInotifyEvent <= n {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			offset = start + int(ev.Len)
+			name := strings.TrimRight(string(buf[start:offset]), "\x00")
+			w.handle(int(ev.Wd), ev.Mask, name, cb)
+		}
+	}
+}
+
+func (w *inotifyWatcher) handle(wd int, mask uint32, name string, cb WatchCallback) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.logger.Printf("inotify event queue overflowed, changes may have been missed\n")
+		return
+	}
+	w.mu.Lock()
+	dir, ok := w.paths[wd]
+	files, only := w.files[wd]
+	if ok && mask&syscall.IN_IGNORED != 0 {
+		delete(w.paths, wd)
+		delete(w.files, wd)
+		ok = false
+	}
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+	path := filepath.Join(dir, name)
+	if only && !files[path] {
+		return
+	}
+	if mask&syscall.IN_ISDIR != 0 {
+		if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 && !w.filter.skipDir(path) {
+			if err := w.addTree(path, cb); err != nil {
+				w.logger.Printf("error watching %s err:%v\n", path, err)
+			}
+		}
+		return
+	}
+	// a created file is reported once it is closed after writing
+	if mask == syscall.IN_CREATE {
+		return
+	}
+	w.filter.notice(path)
+	if w.filter.match(path) {
+		cb(path)
+	}
+}
+
+// Add subscribes to another tree, files already present are not reported.
+// A file is subscribed to on its own.
+func (w *inotifyWatcher) Add(dir string) error {
+	if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+		return w.addFile(dir)
+	}
+	return w.addTree(dir, nil)
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package gommm
+
+import (
+	"errors"
+	"log"
+)
+
+func newInotifyWatcher(dir string, filter *watchFilter, logger *log.Logger) (Watcher, error) {
+	return nil, errors.New("inotify is only supported on linux")
+}
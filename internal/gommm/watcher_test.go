@@ -0,0 +1,248 @@
+package gommm_test
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wxio/gommm/internal/gommm"
+)
+
+func watchChanges(t *testing.T, dir string, filter gommm.Filter, poll bool) (<-chan string, func()) {
+	watcher, err := gommm.NewWatcher(dir, filter, poll, log.New(os.Stdout, "[gommm] ", 0))
+	if err != nil {
+		t.Fatalf("Could not create watcher: %v", err)
+	}
+	changes := make(chan string, 16)
+	go watcher.Watch(func(path string) {
+		changes <- path
+	})
+	return changes, func() { watcher.Close() }
+}
+
+func expectChange(t *testing.T, changes <-chan string, path string) {
+	select {
+	case got := <-changes:
+		expect(t, got, path)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("No change reported for %s", path)
+	}
+}
+
+func expectNoChange(t *testing.T, changes <-chan string) {
+	select {
+	case got := <-changes:
+		t.Fatalf("Unexpected change reported for %s", got)
+	case <-time.After(1500 * time.Millisecond):
+	}
+}
+
+func Test_Watcher_Inotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "vendor"), 0755)
+
+	changes, stop := watchChanges(t, dir, gommm.Filter{Include: []string{"**/*.go"}, Exclude: []string{"vendor"}}, false)
+	defer stop()
+
+	file := filepath.Join(dir, "main.go")
+	ioutil.WriteFile(file, []byte("package main\n"), 0644)
+	expectChange(t, changes, file)
+
+	// newly created directories are picked up
+	sub := filepath.Join(dir, "sub")
+	os.Mkdir(sub, 0755)
+	time.Sleep(100 * time.Millisecond)
+	file = filepath.Join(sub, "sub.go")
+	ioutil.WriteFile(file, []byte("package sub\n"), 0644)
+	expectChange(t, changes, file)
+
+	ioutil.WriteFile(filepath.Join(dir, "vendor", "v.go"), []byte("package v\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("readme\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, ".hidden.go"), []byte("package main\n"), 0644)
+	expectNoChange(t, changes)
+}
+
+func Test_Watcher_Poll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	changes, stop := watchChanges(t, dir, gommm.Filter{Include: []string{"**/*.go"}}, true)
+	defer stop()
+
+	time.Sleep(100 * time.Millisecond)
+	file := filepath.Join(dir, "main.go")
+	ioutil.WriteFile(file, []byte("package main\n"), 0644)
+	expectChange(t, changes, file)
+}
+
+func testWatcherFilter(t *testing.T, poll bool) {
+	dir, err := ioutil.TempDir("", "gommm_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, sub := range []string{"node_modules/pkg", "web/dist", "web/src", "gen", "cmd/api"} {
+		os.MkdirAll(filepath.Join(dir, sub), 0755)
+	}
+	ioutil.WriteFile(filepath.Join(dir, ".gitignore"), []byte("# generated\ngen/\n*.pb.go\n!keep.pb.go\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "cmd", ".ignore"), []byte("/api/skip.go\n"), 0644)
+
+	changes, stop := watchChanges(t, dir, gommm.Filter{
+		Include:   []string{"**/*.go", "web/**/*.tmpl", "web/.env"},
+		Exclude:   []string{"**/node_modules", "web/dist"},
+		GitIgnore: true,
+		Files:     []string{filepath.Join(dir, ".env")},
+	}, poll)
+	defer stop()
+	time.Sleep(100 * time.Millisecond)
+
+	for _, file := range []string{
+		"node_modules/pkg/x.go",
+		"web/dist/x.tmpl",
+		"gen/x.go",
+		"x.pb.go",
+		"cmd/api/skip.go",
+		"web/src/x.js",
+		".env.local",
+		"web/.hidden.tmpl",
+	} {
+		ioutil.WriteFile(filepath.Join(dir, file), []byte("x\n"), 0644)
+	}
+	expectNoChange(t, changes)
+
+	for _, file := range []string{"web/src/x.tmpl", "keep.pb.go", "cmd/api/main.go", ".env", "web/.env"} {
+		ioutil.WriteFile(filepath.Join(dir, file), []byte("x\n"), 0644)
+		expectChange(t, changes, filepath.Join(dir, file))
+	}
+}
+
+func Test_Watcher_Filter_Inotify(t *testing.T) {
+	testWatcherFilter(t, false)
+}
+
+func Test_Watcher_Filter_Poll(t *testing.T) {
+	testWatcherFilter(t, true)
+}
+
+func Test_Watcher_ExcludeDir(t *testing.T) {
+	// --path is relative to the working directory, as is the src/vendor of gommm -t src -x src/vendor
+	dir, err := ioutil.TempDir(".", "gommm_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir = filepath.Clean(dir)
+	for _, sub := range []string{"vendor", "gen", "third_party"} {
+		os.Mkdir(filepath.Join(dir, sub), 0755)
+	}
+
+	changes, stop := watchChanges(t, dir, gommm.Filter{
+		Include:    []string{"**/*.go"},
+		ExcludeDir: []string{filepath.Join(dir, "vendor") + "/", "./gen", "third_party"},
+	}, true)
+	defer stop()
+	time.Sleep(100 * time.Millisecond)
+
+	for _, sub := range []string{"vendor", "gen", "third_party"} {
+		ioutil.WriteFile(filepath.Join(dir, sub, "x.go"), []byte("package x\n"), 0644)
+	}
+	expectNoChange(t, changes)
+
+	file := filepath.Join(dir, "main.go")
+	ioutil.WriteFile(file, []byte("package main\n"), 0644)
+	expectChange(t, changes, file)
+}
+
+func testWatcherAdd(t *testing.T, poll bool) {
+	dir, err := ioutil.TempDir("", "gommm_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	other, err := ioutil.TempDir("", "gommm_watch_other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(other)
+	ioutil.WriteFile(filepath.Join(other, "old.go"), []byte("package other\n"), 0644)
+
+	watcher, err := gommm.NewWatcher(dir, gommm.Filter{Include: []string{"**/*.go"}}, poll, log.New(os.Stdout, "[gommm] ", 0))
+	if err != nil {
+		t.Fatalf("Could not create watcher: %v", err)
+	}
+	defer watcher.Close()
+	changes := make(chan string, 16)
+	go watcher.Watch(func(path string) {
+		changes <- path
+	})
+	expect(t, watcher.Add(other), nil)
+	// files already present are not reported
+	expectNoChange(t, changes)
+
+	file := filepath.Join(other, "other.go")
+	ioutil.WriteFile(file, []byte("package other\n"), 0644)
+	expectChange(t, changes, file)
+}
+
+func Test_Watcher_Add_Inotify(t *testing.T) {
+	testWatcherAdd(t, false)
+}
+
+func Test_Watcher_Add_Poll(t *testing.T) {
+	testWatcherAdd(t, true)
+}
+
+func testWatcherAddFile(t *testing.T, poll bool) {
+	dir, err := ioutil.TempDir("", "gommm_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	other, err := ioutil.TempDir("", "gommm_watch_other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(other)
+	env := filepath.Join(other, ".env")
+	ioutil.WriteFile(env, []byte("A=1\n"), 0644)
+
+	filter := gommm.Filter{Include: []string{"**/*.go"}, Files: []string{env}}
+	watcher, err := gommm.NewWatcher(dir, filter, poll, log.New(os.Stdout, "[gommm] ", 0))
+	if err != nil {
+		t.Fatalf("Could not create watcher: %v", err)
+	}
+	defer watcher.Close()
+	changes := make(chan string, 16)
+	go watcher.Watch(func(path string) {
+		changes <- path
+	})
+	expect(t, watcher.Add(env), nil)
+	expectNoChange(t, changes)
+
+	// only the file is reported of its directory
+	ioutil.WriteFile(filepath.Join(other, "other.go"), []byte("package other\n"), 0644)
+	os.Mkdir(filepath.Join(other, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(other, "sub", "sub.go"), []byte("package sub\n"), 0644)
+	expectNoChange(t, changes)
+	time.Sleep(10 * time.Millisecond)
+	ioutil.WriteFile(env, []byte("A=2\n"), 0644)
+	expectChange(t, changes, env)
+}
+
+func Test_Watcher_AddFile_Inotify(t *testing.T) {
+	testWatcherAddFile(t, false)
+}
+
+func Test_Watcher_AddFile_Poll(t *testing.T) {
+	testWatcherAddFile(t, true)
+}
@@ -1,17 +1,19 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"errors"
+	"context"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
-	"text/template"
 	"time"
 
 	"github.com/jpillora/opts"
@@ -25,41 +27,122 @@ var (
 )
 
 type root struct {
-	Bin         string   `opts:"env=GOMMM_BIN,short=b" help:"Name of generated binary file (default .gommm)"`
-	Path        string   `opts:"env=GOMMM_PATH,short=t" help:"Path to watch files (default .)"`
-	Build       string   `opts:"env=GOMMM_BUILD,short=d" help:"Path to build files  (defaults to --path)"`
-	ExcludeDir  []string `opts:"env=GOMMM_EXCLUDE_DIR,short=x" help:"Relative directories to exclude"`
-	All         bool     `opts:"env=GOMMM_ALL,short=a" help:"Reloads whenever any file changes"`
-	BuildArgs   []string `opts:"env=GOMMM_BUILD_ARGS,short=r" help:"Additional go build arguments"`
-	LogPrefix   string   `opts:"env=GOMMM_LOG_PREFIX" help:"Log prefix (default gommm)"`
-	EnvFile     []string `opts:"env=GOMMM_ENV_FILE" help:"Env files to read. Later entries take precedent, Expansion applied to vars and template (default .env)"`
-	GoModVendor bool     `opts:"env=GOMMM_GOMOD_VENDOR" help:"run 'go mod vendor' before building"`
-	FailIfFirst bool     `opts:"env=GOMMM_FAIL_1ST" help:"fail is first build returns an error"`
-	Run         run      `opts:"mode=cmd" help:"run the command"`
-	Environment env      `opts:"mode=cmd" help:"output the constructed environent"`
-	Version     ver      `opts:"mode=cmd" help:"print version"`
+	Bin             string        `opts:"env=GOMMM_BIN,short=b" help:"Name of generated binary file (default .gommm)"`
+	Path            string        `opts:"env=GOMMM_PATH,short=t" help:"Path to watch files (default .)"`
+	Build           string        `opts:"env=GOMMM_BUILD,short=d" help:"Path to build files  (defaults to --path)"`
+	ExcludeDir      []string      `opts:"env=GOMMM_EXCLUDE_DIR,short=x" help:"Directories to exclude, relative to --path or the working directory"`
+	All             bool          `opts:"env=GOMMM_ALL,short=a" help:"Reloads whenever any file changes, same as --action '**=rebuild'"`
+	BuildArgs       []string      `opts:"env=GOMMM_BUILD_ARGS,short=r" help:"Additional go build arguments"`
+	LogPrefix       string        `opts:"env=GOMMM_LOG_PREFIX" help:"Log prefix (default gommm)"`
+	EnvFile         []string      `opts:"env=GOMMM_ENV_FILE" help:"Env files to read. Later entries take precedent, Expansion applied to vars and template. The app is restarted when they change (default .env)"`
+	GoModVendor     bool          `opts:"env=GOMMM_GOMOD_VENDOR" help:"run 'go mod vendor' before building"`
+	FailIfFirst     bool          `opts:"env=GOMMM_FAIL_1ST" help:"fail is first build returns an error"`
+	Poll            bool          `opts:"env=GOMMM_POLL" help:"poll the file tree for changes instead of using inotify"`
+	Debounce        time.Duration `opts:"env=GOMMM_DEBOUNCE" help:"quiet period collecting changes before rebuilding (default 300ms)"`
+	Include         []string      `opts:"env=GOMMM_INCLUDE,short=I" help:"Glob of files to watch in addition to **/*.go, ** matches any number of directories"`
+	Exclude         []string      `opts:"env=GOMMM_EXCLUDE" help:"Glob of files and directories not to watch, e.g. **/node_modules or web/dist"`
+	GitIgnore       bool          `opts:"env=GOMMM_GITIGNORE" help:"Do not watch what .gitignore and .ignore files ignore"`
+	ReadyTCP        string        `opts:"env=GOMMM_READY_TCP" help:"address the app has to accept connections on before it counts as started, not an address of --listen"`
+	ReadyHTTP       string        `opts:"env=GOMMM_READY_HTTP" help:"url the app has to answer with --ready-status before it counts as started"`
+	ReadyStatus     int           `opts:"env=GOMMM_READY_STATUS" help:"status expected from --ready-http (default 200)"`
+	ReadyLog        string        `opts:"env=GOMMM_READY_LOG" help:"regular expression a line of the app output has to match before it counts as started"`
+	ReadyTimeout    time.Duration `opts:"env=GOMMM_READY_TIMEOUT" help:"time for the app to become ready (default 10s)"`
+	Action          []string      `opts:"env=GOMMM_ACTION" help:"glob=action for changed files, action is rebuild, restart, ignore or run:<command> to run before rebuilding. The first match wins, e.g. **/*.tmpl=restart"`
+	PreBuild        []string      `opts:"env=GOMMM_PRE_BUILD" help:"command to run before each build, e.g. 'go generate ./...'"`
+	PostBuild       []string      `opts:"env=GOMMM_POST_BUILD" help:"command to run after each successful build, the new binary is in GOMMM_BINARY"`
+	Pipeline        string        `opts:"env=GOMMM_PIPELINE" help:"json file of pre_build and post_build steps with name, command, dir, env, timeout and continue, run before --pre-build and --post-build"`
+	VerifyVet       bool          `opts:"env=GOMMM_VERIFY_VET" help:"run go vet on the changed packages after each build, the app is only restarted when it passes"`
+	VerifyTest      bool          `opts:"env=GOMMM_VERIFY_TEST" help:"run go test on the changed packages after each build, the app is only restarted when they pass"`
+	Procfile        string        `opts:"env=GOMMM_PROCFILE" help:"file of processes for run to build and run side by side, lines of 'name: [KEY=VALUE ...] build-dir [args ...]' or a .json file"`
+	Restart         string        `opts:"env=GOMMM_RESTART" help:"restart the app when it exits on its own, never, on-failure or always (default never)"`
+	RestartBackoff  time.Duration `opts:"env=GOMMM_RESTART_BACKOFF" help:"delay before restarting, doubled with each consecutive restart up to 30s (default 500ms)"`
+	RestartMax      int           `opts:"env=GOMMM_RESTART_MAX" help:"consecutive restarts before giving up (default unlimited)"`
+	CrashLoop       int           `opts:"env=GOMMM_CRASH_LOOP,short=C" help:"exits within --crash-loop-window that stop restarting (default 5)"`
+	CrashLoopWindow time.Duration `opts:"env=GOMMM_CRASH_LOOP_WINDOW,short=W" help:"window of --crash-loop (default 1m)"`
+	CrashLoopLines  int           `opts:"env=GOMMM_CRASH_LOOP_LINES,short=L" help:"lines of the app output reported when restarting stops (default 20)"`
+	StopSignal      string        `opts:"env=GOMMM_STOP_SIGNAL" help:"signal asking the app to stop, SIGTERM, SIGINT, SIGQUIT or SIGHUP (default SIGINT)"`
+	StopTimeout     time.Duration `opts:"env=GOMMM_STOP_TIMEOUT" help:"time for the app to stop before it is killed (default 3s)"`
+	Listen          []string      `opts:"env=GOMMM_LISTEN" help:"address to listen on and hand to the app as file descriptor 3 and up, with LISTEN_FDS and LISTEN_PID set like systemd socket activation. The new app is started before the old one is stopped, so connections are never refused"`
+	Run             run           `opts:"mode=cmd" help:"run the command"`
+	Proxy           proxy         `opts:"mode=cmd" help:"run the command behind a proxy, restarting it on the next request after a rebuild"`
+	Test            test          `opts:"mode=cmd" help:"rerun the tests of the packages affected by changed files"`
+	Environment     env           `opts:"mode=cmd" help:"output the constructed environent"`
+	Version         ver           `opts:"mode=cmd" help:"print version"`
 	//
-	env        map[string][]envvar
-	startTime  time.Time
-	logger     *log.Logger
-	colorGreen string
-	colorRed   string
-	colorReset string
-	count      int
+	env         map[string][]envvar
+	environ     []string
+	envFiles    []string
+	apps        []app
+	logger      *log.Logger
+	colorGreen  string
+	colorRed    string
+	colorReset  string
+	count       int
+	graphs      []*gommm.Graph
+	actions     []gommm.Action
+	processes   []gommm.ProcessChanges
+	envChanged  func()
+	mu          sync.Mutex
+	cancelBuild context.CancelFunc
+	cancelled   bool
+	built       chan struct{}
 }
 
+// process is an entry of the procfile with its builder and runner
+type process struct {
+	gommm.Process
+	logger  *log.Logger
+	builder gommm.Builder
+	runner  gommm.Runner
+	graph   *gommm.Graph
+}
+
+// app is a runner restarted with the new environment when the env files change,
+// its builder builds with it from then on
+type app struct {
+	logger  *log.Logger
+	builder gommm.Builder
+	runner  gommm.Runner
+	start   bool
+}
+
+// processColors tell the output of the processes apart
+var processColors = []int{36, 33, 35, 34, 32, 31}
+
 type envvar struct {
 	form string
 	val  string
 	file string
+	line int
 }
 
 type run struct {
 	rt   *root
 	Args []string `opts:"mode=arg" help:"command to run"`
 }
+type proxy struct {
+	rt         *root
+	Laddr      string        `opts:"env=GOMMM_LADDR,short=l" help:"listening address for the proxy server"`
+	Port       int           `opts:"env=GOMMM_PORT,short=p" help:"port for the proxy server (default 3000)"`
+	AppPort    int           `opts:"env=GOMMM_APP_PORT" help:"port for the Go web server, exported to it as PORT (default 3001)"`
+	ProxyTo    string        `opts:"env=GOMMM_PROXY_TO" help:"url of the Go web server (default http://localhost:<app-port>)"`
+	CertFile   string        `opts:"env=GOMMM_CERT_FILE" help:"TLS Certificate"`
+	KeyFile    string        `opts:"env=GOMMM_KEY_FILE" help:"TLS Certificate Key"`
+	Immediate  bool          `opts:"env=GOMMM_IMMEDIATE,short=i" help:"run the server immediately after it's built"`
+	Timeout    time.Duration `opts:"env=GOMMM_TIMEOUT" help:"time to hold requests while building before responding 503 (default 30s)"`
+	LiveReload bool          `opts:"env=GOMMM_LIVE_RELOAD" help:"inject a script into html pages reloading them after each successful rebuild"`
+	Args       []string      `opts:"mode=arg" help:"command to run"`
+}
+type test struct {
+	rt       *root
+	Filter   string   `opts:"name=run,env=GOMMM_TEST_RUN" help:"run only tests matching the regular expression, as go test -run"`
+	Race     bool     `opts:"env=GOMMM_TEST_RACE" help:"enable the race detector, as go test -race"`
+	Packages []string `opts:"mode=arg" help:"packages to test (default ./...)"`
+}
 type env struct {
-	rt *root
+	rt     *root
+	Format string `opts:"env=GOMMM_ENV_FORMAT" help:"output format, dotenv, shell for eval, json including where each variable was defined or docker for --env-file (default dotenv)"`
+	All    bool   `help:"include the variables inherited from the environment of gommm"`
 }
 type ver struct {
 	rt *root
@@ -67,7 +150,8 @@ type ver struct {
 
 func main() {
 	gm0 := &root{
-		logger: log.New(os.Stdout, "[gommm-env] ", 0),
+		// stdout is kept for the output of the environment command
+		logger: log.New(os.Stderr, "[gommm-env] ", 0),
 	}
 	opts.New(gm0).Name("gommm").Complete().UserConfigPath().Parse()
 	if len(gm0.EnvFile) == 0 {
@@ -79,16 +163,24 @@ func main() {
 		Bin:        ".gommm",
 		Path:       ".",
 		LogPrefix:  "gommm",
-		startTime:  time.Now(),
+		Debounce:   300 * time.Millisecond,
 		logger:     log.New(os.Stdout, "[gommm] ", 0),
 		colorGreen: string([]byte{27, 91, 57, 55, 59, 51, 50, 59, 49, 109}),
 		colorRed:   string([]byte{27, 91, 57, 55, 59, 51, 49, 59, 49, 109}),
 		colorReset: string([]byte{27, 91, 48, 109}),
 	}
 	gommm.Run.rt = gommm
+	gommm.Proxy.rt = gommm
+	gommm.Proxy.Port = 3000
+	gommm.Proxy.AppPort = 3001
+	gommm.Test.rt = gommm
 	gommm.Environment.rt = gommm
 	gommm.Version.rt = gommm
+	gommm.env, gommm.environ, gommm.envFiles = gm0.env, gm0.environ, gm0.envFiles
+	// GOMMM_ variables of the env files configure gommm, only while parsing the options
+	restore := setenv(gm0.environ, "GOMMM_")
 	op := opts.New(gommm).Name("gommm").Complete().UserConfigPath().Parse()
+	restore()
 	if gommm.Build == "" {
 		gommm.Build = gommm.Path
 	}
@@ -97,119 +189,528 @@ func main() {
 	return
 }
 
+// evalenv reads the env files into the environment of the app, leaving gommm's own alone
 func (cfg *root) evalenv() {
-	cfg.env = make(map[string][]envvar)
-	data := struct {
-		Env map[string]string
-	}{
-		Env: make(map[string]string),
+	if cfg.envFiles == nil {
+		for _, env := range cfg.EnvFile {
+			file := env
+			if !filepath.IsAbs(env) {
+				file = filepath.Join(cfg.Path, env)
+			}
+			if abs, err := filepath.Abs(file); err == nil {
+				file = abs
+			}
+			cfg.envFiles = append(cfg.envFiles, file)
+		}
 	}
+	cfg.env = make(map[string][]envvar)
+	vals := make(map[string]string)
 	for _, kv := range os.Environ() {
 		if i := strings.Index(kv, "="); i > 0 {
 			ke, va := kv[:i], kv[i+1:]
-			data.Env[ke] = va
+			vals[ke] = va
 		}
 	}
-	for _, env := range cfg.EnvFile {
-		file := env
-		if !filepath.IsAbs(env) {
-			file = filepath.Join(cfg.Path, env)
-		}
-
-		fr, err := os.Open(file)
+	var environ []string
+	for _, file := range cfg.envFiles {
+		vars, err := gommm.LoadDotenv(file)
 		if err != nil {
 			cfg.logger.Printf("error reading env %s err %v\n", file, err)
 			continue
 		}
-		scanner := bufio.NewScanner(fr)
-		for scanner.Scan() {
-			line := scanner.Text()
-			kv := strings.Split(line, "#")
-			if strings.Contains(line, "\\#") {
-				kv = []string{strings.ReplaceAll(line, "\\#", "#")}
-			}
-			if i := strings.Index(kv[0], "="); i > 0 {
-				ke, va := kv[0][:i], kv[0][i+1:]
-				// fmt.Printf("-- k:'%s' v:'%s'\n", ke, va)
-				en := cfg.env[ke]
-				val := os.ExpandEnv(va)
-				tpl, err := template.New("").Parse(val)
-				if err != nil {
-					cfg.logger.Printf("error in template parse env %s:%s err %v\n", ke, val, err)
-				} else {
-					buf := bytes.Buffer{}
-					err = tpl.Execute(&buf, data)
-					if err != nil {
-						cfg.logger.Printf("error in template execute env %s:%s err %v\n", ke, val, err)
-					} else {
-						val = buf.String()
-					}
-				}
-				cfg.env[ke] = append(en, envvar{form: va, val: val, file: file})
-				os.Setenv(ke, val)
-				data.Env[ke] = val
+		for _, v := range vars {
+			val, err := gommm.ExpandDotenv(v, vals)
+			if err != nil {
+				cfg.logger.Printf("error in template env %s:%s err %v\n", v.Key, v.Value, err)
 			}
+			cfg.env[v.Key] = append(cfg.env[v.Key], envvar{form: v.Value, val: val, file: file, line: v.Line})
+			environ = append(environ, v.Key+"="+val)
+			vals[v.Key] = val
+		}
+	}
+	cfg.environ = gommm.MergeEnv(os.Environ(), environ)
+}
+
+// reloadEnv evaluates the env files again when paths include one of them, restarting the apps
+// and reloading the package graphs with the new environment. It returns the other paths and
+// whether the environment changed.
+func (cfg *root) reloadEnv(watcher gommm.Watcher, paths []string) ([]string, bool) {
+	var others []string
+	for _, path := range paths {
+		if !cfg.changes().IsEnvFile(path) {
+			others = append(others, path)
+		}
+	}
+	if len(others) == len(paths) {
+		return paths, false
+	}
+	old := cfg.environ
+	cfg.evalenv()
+	added, removed, changed := gommm.DiffEnv(old, cfg.environ)
+	if len(added)+len(removed)+len(changed) == 0 {
+		cfg.logger.Println("Env files changed, the environment did not")
+		return others, false
+	}
+	var diff []string
+	for _, d := range []struct {
+		what string
+		keys []string
+	}{{"added", added}, {"removed", removed}, {"changed", changed}} {
+		if len(d.keys) > 0 {
+			diff = append(diff, d.what+" "+strings.Join(d.keys, " "))
+		}
+	}
+	cfg.logger.Printf("Environment changed: %s\n", strings.Join(diff, ", "))
+	for _, a := range cfg.apps {
+		a.builder.SetEnviron(cfg.environ)
+		a.runner.SetEnviron(cfg.environ)
+		cfg.restart(a.logger, a.runner, a.start)
+	}
+	for _, graph := range cfg.graphs {
+		graph.SetEnviron(cfg.environ)
+		if err := graph.Load(context.Background()); err != nil {
+			cfg.logger.Printf("error refreshing the package graph err:%v\n", err)
+		}
+	}
+	cfg.watchRoots(watcher)
+	return others, true
+}
+
+// setenv sets the variables of environ starting with prefix in gommm's own environment,
+// returning a func restoring it
+func setenv(environ []string, prefix string) func() {
+	var undo []func()
+	for _, kv := range environ {
+		i := strings.Index(kv, "=")
+		if i < 0 || !strings.HasPrefix(kv, prefix) {
+			continue
+		}
+		ke := kv[:i]
+		if old, ok := os.LookupEnv(ke); ok {
+			undo = append(undo, func() { os.Setenv(ke, old) })
+		} else {
+			undo = append(undo, func() { os.Unsetenv(ke) })
+		}
+		os.Setenv(ke, kv[i+1:])
+	}
+	return func() {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
 		}
 	}
 }
 
 func (cmd *run) Run() error {
+	if cmd.rt.Procfile != "" {
+		return cmd.rt.runProcfile()
+	}
+	builder, runner, watcher := cmd.rt.setup(cmd.Args)
+	defer watcher.Close()
+	cmd.rt.apps = append(cmd.rt.apps, app{logger: cmd.rt.logger, builder: builder, runner: runner, start: true})
+	// build right now
+	cmd.rt.build(cmd.rt.logger, builder, runner, true)
+	// watch for changes, the app keeps running until a successful build replaces it
+	return cmd.rt.watch(watcher, func(paths []string) {
+		cmd.rt.logger.Printf("Changed: %s\n", strings.Join(paths, " "))
+		cmd.rt.apply(paths, builder, runner, true)
+	})
+}
+
+func (cmd *proxy) Run() error {
+	if cmd.ProxyTo == "" {
+		cmd.ProxyTo = fmt.Sprintf("http://localhost:%d", cmd.AppPort)
+	}
+	builder, runner, watcher := cmd.rt.setup(cmd.Args)
+	defer watcher.Close()
+	// the app is expected to bind to PORT
+	runner.SetEnv([]string{"PORT=" + strconv.Itoa(cmd.AppPort)})
+	cmd.rt.apps = append(cmd.rt.apps, app{logger: cmd.rt.logger, builder: builder, runner: runner, start: cmd.Immediate})
+	if cmd.rt.ReadyTCP == "" && cmd.rt.ReadyHTTP == "" && cmd.rt.ReadyLog == "" && len(cmd.rt.Listen) == 0 {
+		// requests can be forwarded once the app accepts connections,
+		// with --listen they queue on the socket until it does
+		to, err := url.Parse(cmd.ProxyTo)
+		if err != nil {
+			return err
+		}
+		runner.SetReadiness(&gommm.Readiness{TCP: to.Host, Timeout: cmd.rt.ReadyTimeout})
+	}
+	px := gommm.NewProxy(builder, runner)
+	err := px.Run(&gommm.Config{
+		Laddr:    cmd.Laddr,
+		Port:     cmd.Port,
+		ProxyTo:  cmd.ProxyTo,
+		CertFile: cmd.CertFile,
+		KeyFile:  cmd.KeyFile,
+		// whole seconds, rounded up so a timeout below a second is not the default
+		Timeout:    int((cmd.Timeout + time.Second - 1) / time.Second),
+		LiveReload: cmd.LiveReload,
+	})
+	if err != nil {
+		return err
+	}
+	defer px.Close()
+	cmd.rt.logger.Printf("Listening on %s:%d proxying to %s\n", cmd.Laddr, cmd.Port, cmd.ProxyTo)
+	// build right now, the proxy starts the app on the first request
+	cmd.rt.build(cmd.rt.logger, builder, runner, cmd.Immediate)
+	// pages held while building reload with the app or the build errors
+	px.Reload()
+	// watch for changes, the app keeps serving until it is refreshed by the next request
+	return cmd.rt.watch(watcher, func(paths []string) {
+		cmd.rt.logger.Printf("Changed: %s\n", strings.Join(paths, " "))
+		if cmd.rt.apply(paths, builder, runner, cmd.Immediate) && builder.State() == gommm.BuildOK {
+			px.Reload()
+		}
+	})
+}
+
+func (cmd *test) Run() error {
+	if len(cmd.Packages) == 0 {
+		cmd.Packages = []string{"./..."}
+	}
+	watcher := cmd.rt.newWatcher(cmd.graphs()...)
+	defer watcher.Close()
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		cmd.rt.stopBuild()
+		os.Exit(1)
+	}()
+	cmd.test(cmd.Packages)
+	// the tests run with the environment of the env files
+	cmd.rt.envChanged = func() {
+		cmd.test(cmd.Packages)
+	}
+	return cmd.rt.watch(watcher, func(paths []string) {
+		cmd.rt.logger.Printf("Changed: %s\n", strings.Join(paths, " "))
+		if len(cmd.rt.graphs) == 0 {
+			cmd.test(cmd.Packages)
+			return
+		}
+		pkgs := cmd.rt.graphs[0].Affected(paths)
+		if len(pkgs) == 0 {
+			cmd.rt.logger.Println("No tests affected")
+			return
+		}
+		cmd.test(pkgs)
+	})
+}
+
+// graphs returns the test graph of the packages, none with --all
+func (cmd *test) graphs() []*gommm.Graph {
+	if cmd.rt.All {
+		return nil
+	}
+	return []*gommm.Graph{gommm.NewTestGraph(cmd.rt.Path, cmd.Packages...)}
+}
+
+// test runs go test on pkgs printing the output of failed packages and a summary.
+// The environment read by evalenv applies to the tests.
+func (cmd *test) test(pkgs []string) {
+	cfg := cmd.rt
+	cfg.logger.Printf("Testing %s\n", strings.Join(pkgs, " "))
+	var args []string
+	if cmd.Filter != "" {
+		args = append(args, "-run", cmd.Filter)
+	}
+	if cmd.Race {
+		args = append(args, "-race")
+	}
+	start := time.Now()
+	ctx, done := cfg.cancellable()
+	results, output, err := gommm.RunTests(ctx, cfg.Path, cfg.environ, args, pkgs)
+	done()
+	if err == context.Canceled {
+		cfg.logger.Println("Tests cancelled, sources changed")
+		return
+	}
+	for _, r := range results {
+		if r.Action == "fail" {
+			fmt.Print(r.Output)
+		}
+	}
+	fmt.Print(output)
+	passed, failed, skipped := 0, 0, 0
+	for _, r := range results {
+		switch r.Action {
+		case "pass":
+			passed++
+			cfg.logger.Printf("%sok%s   %s %s\n", cfg.colorGreen, cfg.colorReset, r.Package, r.Elapsed.Round(time.Millisecond))
+		case "fail":
+			failed++
+			cfg.logger.Printf("%sFAIL%s %s %s %s\n", cfg.colorRed, cfg.colorReset, r.Package, r.Elapsed.Round(time.Millisecond), strings.Join(r.Failed, " "))
+		default:
+			skipped++
+		}
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if failed > 0 || (err != nil && len(results) == 0) {
+		cfg.logger.Printf("%sTests failed%s: %d passed, %d failed, %d without tests in %s\n", cfg.colorRed, cfg.colorReset, passed, failed, skipped, elapsed)
+	} else {
+		cfg.logger.Printf("%sTests passed%s: %d passed, %d without tests in %s\n", cfg.colorGreen, cfg.colorReset, passed, skipped, elapsed)
+	}
+}
+
+// runProcfile builds and runs the processes of the procfile, rebuilding those affected by changes
+func (cfg *root) runProcfile() error {
+	procs, err := gommm.LoadProcfile(cfg.Procfile)
+	if err != nil {
+		return err
+	}
+	cfg.parseActions()
+	var (
+		processes []*process
+		graphs    []*gommm.Graph
+		runners   []gommm.Runner
+	)
+	for i, proc := range procs {
+		name := fmt.Sprintf("\x1b[%dm%s%s", processColors[i%len(processColors)], proc.Name, cfg.colorReset)
+		p := &process{Process: proc, logger: log.New(os.Stdout, fmt.Sprintf("[%s] %s ", cfg.LogPrefix, name), 0)}
+		p.builder = cfg.newBuilder(proc.Build, cfg.Bin+"-"+proc.Name, p.logger)
+		p.runner = cfg.newRunner(p.builder, p.logger, proc.Args, proc.Policy)
+		// the process overrides --stop-signal and --stop-timeout, LoadProcfile validated the signal
+		var signal os.Signal
+		if proc.StopSignal != "" {
+			signal, _ = gommm.ParseSignal(proc.StopSignal)
+		}
+		p.runner.SetStop(signal, time.Duration(proc.StopTimeout)*time.Second)
+		p.runner.SetWriter(gommm.NewPrefixWriter(os.Stdout, name+" | "))
+		p.runner.SetEnv(proc.Env)
+		if !cfg.All {
+			p.graph = gommm.NewGraph(proc.Build, ".")
+			graphs = append(graphs, p.graph)
+		}
+		cfg.Include = append(cfg.Include, proc.Restart...)
+		cfg.processes = append(cfg.processes, gommm.ProcessChanges{Restart: proc.Restart, Graph: p.graph})
+		processes = append(processes, p)
+		runners = append(runners, p.runner)
+		cfg.apps = append(cfg.apps, app{logger: p.logger, builder: p.builder, runner: p.runner, start: true})
+	}
+	watcher := cfg.newWatcher(graphs...)
+	defer watcher.Close()
+	cfg.shutdown(runners...)
+	for _, p := range processes {
+		cfg.build(p.logger, p.builder, p.runner, true)
+	}
+	return cfg.watch(watcher, func(paths []string) {
+		cfg.logger.Printf("Changed: %s\n", strings.Join(paths, " "))
+		plan := cfg.changes().PlanProcesses(paths)
+		for _, command := range plan.Commands {
+			if !cfg.command(command) {
+				return
+			}
+		}
+		for i, p := range processes {
+			if cfg.superseded() {
+				// watch hands the paths on again with the next batch
+				return
+			}
+			switch {
+			case plan.Rebuild[i]:
+				p.builder.Changed(plan.Changed)
+				cfg.build(p.logger, p.builder, p.runner, true)
+			case plan.Restart[i]:
+				cfg.restart(p.logger, p.runner, true)
+			}
+		}
+	})
+}
+
+// setup creates the builder, the runner for its binary and the watcher shared by run and proxy
+func (cfg *root) setup(args []string) (gommm.Builder, gommm.Runner, gommm.Watcher) {
 	// buildArgs, err := shellwords.Parse(c.GlobalString("buildArgs"))
 	// if err != nil {
 	// 	logger.Fatal(err)
 	// }
+	builder := cfg.newBuilder(cfg.Build, cfg.Bin, cfg.logger)
+	runner := cfg.newRunner(builder, cfg.logger, args, "")
+	runner.SetWriter(os.Stdout)
+	if cfg.ReadyTCP != "" || cfg.ReadyHTTP != "" || cfg.ReadyLog != "" {
+		ready := &gommm.Readiness{
+			TCP:     cfg.ReadyTCP,
+			HTTP:    cfg.ReadyHTTP,
+			Status:  cfg.ReadyStatus,
+			Timeout: cfg.ReadyTimeout,
+		}
+		if cfg.ReadyLog != "" {
+			var err error
+			ready.Log, err = regexp.Compile(cfg.ReadyLog)
+			if err != nil {
+				cfg.logger.Fatalf("invalid --ready-log err:%v\n", err)
+			}
+		}
+		runner.SetReadiness(ready)
+	}
+	if len(cfg.Listen) > 0 {
+		files, err := gommm.Listen(cfg.Listen)
+		if err != nil {
+			cfg.logger.Fatal(err)
+		}
+		runner.SetListeners(files)
+	}
+	cfg.parseActions()
+	var graphs []*gommm.Graph
+	if !cfg.All {
+		// only watch the .go files the build depends on
+		graphs = append(graphs, gommm.NewGraph(cfg.Build, "."))
+	}
+	// subscribe before the first build so changes made during it are seen
+	watcher := cfg.newWatcher(graphs...)
+	// shutdown handler
+	cfg.shutdown(runner)
+	return builder, runner, watcher
+}
+
+// newBuilder creates a builder of the main package in dir, running the configured pipeline
+func (cfg *root) newBuilder(dir string, bin string, logger *log.Logger) gommm.Builder {
 	wd, err := os.Getwd()
 	if err != nil {
-		cmd.rt.logger.Fatal(err)
+		cfg.logger.Fatal(err)
 	}
 	builder := gommm.NewBuilder(
-		cmd.rt.Path,
-		cmd.rt.Bin,
+		dir,
+		bin,
 		wd,
-		cmd.rt.logger,
-		cmd.rt.GoModVendor,
-		cmd.rt.BuildArgs,
+		cfg.environ,
+		logger,
+		cfg.GoModVendor,
+		cfg.BuildArgs,
 	)
+	pipeline := &gommm.Pipeline{}
+	if cfg.Pipeline != "" {
+		pipeline, err = gommm.LoadPipeline(cfg.Pipeline)
+		if err != nil {
+			cfg.logger.Fatal(err)
+		}
+	}
+	for _, command := range cfg.PreBuild {
+		pipeline.PreBuild = append(pipeline.PreBuild, gommm.Step{Name: command, Command: command})
+	}
+	for _, command := range cfg.PostBuild {
+		pipeline.PostBuild = append(pipeline.PostBuild, gommm.Step{Name: command, Command: command})
+	}
+	builder.SetSteps(pipeline.PreBuild, pipeline.PostBuild)
+	builder.SetVerify(cfg.VerifyVet, cfg.VerifyTest)
+	return builder
+}
+
+// newRunner creates a runner of the binary of builder, restarting it according to policy
+// or else --restart
+func (cfg *root) newRunner(builder gommm.Builder, logger *log.Logger, args []string, policy string) gommm.Runner {
+	wd, err := os.Getwd()
+	if err != nil {
+		cfg.logger.Fatal(err)
+	}
 	runner := gommm.NewRunner(
 		filepath.Join(wd, builder.Binary()),
-		cmd.rt.logger,
-		cmd.Args...,
+		cfg.environ,
+		logger,
+		args...,
 	)
-	runner.SetWriter(os.Stdout)
-	// shutdown handler
-	shutdown(runner)
-	// build right now
-	cmd.rt.build(builder, runner)
-	// scan for changes
-	cmd.rt.scanChanges(
-		cmd.rt.Path,
-		cmd.rt.ExcludeDir,
-		cmd.rt.All,
-		func(path string) {
-			runner.Kill()
-			cmd.rt.build(builder, runner)
-		},
-	)
-	return nil
+	if runtime.GOOS == "windows" {
+		// the binary of the running app cannot be replaced
+		builder.SetRelease(runner.Kill)
+	}
+	if policy == "" {
+		policy = cfg.Restart
+	}
+	restart := &gommm.Restart{
+		Backoff:         cfg.RestartBackoff,
+		MaxRetries:      cfg.RestartMax,
+		CrashLoop:       cfg.CrashLoop,
+		CrashLoopWindow: cfg.CrashLoopWindow,
+		Lines:           cfg.CrashLoopLines,
+	}
+	if restart.Policy, err = gommm.ParseRestartPolicy(policy); err != nil {
+		cfg.logger.Fatalf("invalid --restart err:%v\n", err)
+	}
+	runner.SetRestart(restart)
+	signal, err := gommm.ParseSignal(cfg.StopSignal)
+	if err != nil {
+		cfg.logger.Fatalf("invalid --stop-signal err:%v\n", err)
+	}
+	runner.SetStop(signal, cfg.StopTimeout)
+	return runner
 }
 
-func (cmd *env) Run() error {
-	fmt.Printf("# env \n")
-	for ke, va := range cmd.rt.env {
-		fmt.Printf("%s=%s\n", ke, va[len(va)-1].val)
+func (cfg *root) parseActions() {
+	for _, a := range cfg.Action {
+		action, err := gommm.ParseAction(a)
+		if err != nil {
+			cfg.logger.Fatalf("invalid --action err:%v\n", err)
+		}
+		cfg.actions = append(cfg.actions, action)
 	}
-	fmt.Printf("# --- \n")
-	fmt.Printf("%+v\n", *cmd.rt)
+}
 
-	for _, kv := range os.Environ() {
-		fmt.Printf("%s\n", kv)
+// newWatcher creates the watcher of --path, restricted to the files of the graphs unless they cannot be loaded
+func (cfg *root) newWatcher(graphs ...*gommm.Graph) gommm.Watcher {
+	filter := gommm.Filter{
+		Include:    append([]string{"**/*.go"}, cfg.Include...),
+		Exclude:    cfg.Exclude,
+		ExcludeDir: cfg.ExcludeDir,
+		GitIgnore:  cfg.GitIgnore,
 	}
+	for _, action := range cfg.actions {
+		if action.Kind != gommm.ActionIgnore {
+			filter.Include = append(filter.Include, action.Pattern)
+		}
+	}
+	if cfg.All {
+		filter.Include = []string{"**"}
+	}
+	for _, graph := range graphs {
+		// go list sees the variables of the env files, e.g. GOFLAGS, like go build
+		graph.SetEnviron(cfg.environ)
+		if err := graph.Load(context.Background()); err != nil {
+			cfg.logger.Printf("watching every .go file, the package graph could not be loaded err:%v\n", err)
+			graphs = nil
+			break
+		}
+	}
+	cfg.graphs = graphs
+	filter.Graphs = graphs
+	// env files are evaluated again when they change
+	filter.Files = cfg.envFiles
+	watcher, err := gommm.NewWatcher(
+		cfg.Path,
+		filter,
+		cfg.Poll,
+		cfg.logger,
+	)
+	if err != nil {
+		cfg.logger.Fatal(err)
+	}
+	cfg.watchRoots(watcher)
+	// only the env files of their directories are watched outside of --path
+	path, _ := filepath.Abs(cfg.Path)
+	for _, file := range cfg.envFiles {
+		if rel, err := filepath.Rel(path, file); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			continue
+		}
+		if err := watcher.Add(file); err != nil {
+			cfg.logger.Printf("error watching %s err:%v\n", file, err)
+		}
+	}
+	return watcher
+}
 
-	fmt.Printf("GOMMM_PATH=%s\n", os.Getenv("GOMMM_PATH"))
-
-	fmt.Printf("GOMMM_PATH=%s\n", cmd.rt.Path)
-
-	return nil
+func (cmd *env) Run() error {
+	var vars []gommm.Env
+	for _, kv := range cmd.rt.environ {
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			continue
+		}
+		v := gommm.Env{Key: kv[:i], Value: kv[i+1:]}
+		for _, def := range cmd.rt.env[v.Key] {
+			v.Sources = append(v.Sources, gommm.EnvSource{File: def.file, Line: def.line, Form: def.form, Value: def.val})
+		}
+		if len(v.Sources) > 0 || cmd.All {
+			vars = append(vars, v)
+		}
+	}
+	return gommm.WriteEnv(os.Stdout, cmd.Format, vars)
 }
 
 func (cmd *ver) Run() error {
@@ -217,63 +718,265 @@ func (cmd *ver) Run() error {
 	return nil
 }
 
-func (cfg *root) build(builder gommm.Builder, runner gommm.Runner) {
-	cfg.logger.Println("Building...")
-	err := builder.Build()
-	if err != nil {
-		cfg.logger.Printf("%sBuild failed%s\n", cfg.colorRed, cfg.colorReset)
-		fmt.Println(builder.Errors())
-		if cfg.FailIfFirst && cfg.count == 0 {
-			os.Exit(1)
+// watch hands debounced batches of changes to cb, one at a time.
+// A batch to be rebuilt cancels the build in progress, the paths of the
+// cancelled batch are handed to cb again along with the next one.
+func (cfg *root) watch(watcher gommm.Watcher, cb gommm.BatchCallback) error {
+	var (
+		mu     sync.Mutex
+		queued []string
+		ready  = make(chan struct{}, 1)
+	)
+	queue := func(paths []string) {
+		mu.Lock()
+		queued = gommm.AppendNew(queued, paths...)
+		mu.Unlock()
+		select {
+		case ready <- struct{}{}:
+		default:
 		}
-	} else {
-		cfg.logger.Printf("%sBuild finished%s\n", cfg.colorGreen, cfg.colorReset)
-		_, err = runner.Run()
-		if err != nil && cfg.FailIfFirst && cfg.count == 0 {
-			os.Exit(1)
+	}
+	go func() {
+		for range ready {
+			mu.Lock()
+			paths := queued
+			queued = nil
+			mu.Unlock()
+			if len(paths) == 0 {
+				continue
+			}
+			cfg.mu.Lock()
+			cfg.cancelled = false
+			cfg.mu.Unlock()
+			cfg.batch(watcher, paths, cb)
+			cfg.mu.Lock()
+			cancelled := cfg.cancelled
+			cfg.mu.Unlock()
+			if cancelled {
+				// the next batch is queued already
+				mu.Lock()
+				queued = cfg.changes().Requeue(paths, queued)
+				mu.Unlock()
+			}
+		}
+	}()
+	return watcher.Watch(gommm.Debounce(cfg.Debounce, func(paths []string) {
+		if cfg.changes().Rebuilds(paths) {
+			cfg.cancel()
 		}
+		queue(paths)
+	}))
+}
+
+// changes returns what decides about the batches of changed paths
+func (cfg *root) changes() *gommm.Changes {
+	return &gommm.Changes{
+		Dir:       cfg.Path,
+		Actions:   cfg.actions,
+		EnvFiles:  cfg.envFiles,
+		Processes: cfg.processes,
+		Graphs:    cfg.graphs,
 	}
-	cfg.count++
-	time.Sleep(100 * time.Millisecond)
 }
 
-type scanCallback func(path string)
+// batch evaluates the env files and refreshes the package graphs for the changed paths
+// before handing the others to cb
+func (cfg *root) batch(watcher gommm.Watcher, paths []string, cb gommm.BatchCallback) {
+	paths, changed := cfg.reloadEnv(watcher, paths)
+	if changed && cfg.envChanged != nil {
+		// the package graphs were reloaded, the new environment applies to everything
+		cfg.envChanged()
+		return
+	}
+	if len(paths) == 0 {
+		return
+	}
+	for _, graph := range cfg.graphs {
+		if graph.ImportsChanged(paths) {
+			if err := graph.Load(context.Background()); err != nil {
+				cfg.logger.Printf("error refreshing the package graph err:%v\n", err)
+			}
+			cfg.watchRoots(watcher)
+		}
+	}
+	cb(paths)
+}
 
-func (cfg *root) scanChanges(watchPath string, excludeDirs []string, allFiles bool, cb scanCallback) {
-	for {
-		filepath.Walk(watchPath, func(path string, info os.FileInfo, err error) error {
-			if path == ".git" && info.IsDir() {
-				return filepath.SkipDir
+// watchRoots adds the module directories of the package graphs outside --path to the watcher,
+// e.g. the directories of local replace directives
+func (cfg *root) watchRoots(watcher gommm.Watcher) {
+	path, err := filepath.Abs(cfg.Path)
+	if err != nil {
+		return
+	}
+	for _, graph := range cfg.graphs {
+		for _, dir := range graph.Roots() {
+			if rel, err := filepath.Rel(path, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+				continue
 			}
-			for _, x := range excludeDirs {
-				if x == path {
-					return filepath.SkipDir
-				}
+			if err := watcher.Add(dir); err != nil {
+				cfg.logger.Printf("error watching %s err:%v\n", dir, err)
+			}
+		}
+	}
+}
+
+// apply carries out the actions matching the changed paths, it reports whether the app was
+// rebuilt or restarted
+func (cfg *root) apply(paths []string, builder gommm.Builder, runner gommm.Runner, start bool) bool {
+	plan := gommm.PlanActions(cfg.actions, cfg.Path, paths)
+	switch {
+	case plan.Rebuild:
+		builder.Changed(paths)
+		for _, command := range plan.Commands {
+			if !cfg.command(command) {
+				return false
 			}
-			// ignore hidden files
-			if filepath.Base(path)[0] == '.' {
-				return nil
+		}
+		cfg.build(cfg.logger, builder, runner, start)
+		return true
+	case plan.Restart:
+		cfg.restart(cfg.logger, runner, start)
+		return true
+	}
+	return false
+}
+
+// cancellable returns a context cancelled by the next change, done has to be called once finished
+func (cfg *root) cancellable() (ctx context.Context, done func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	built := make(chan struct{})
+	cfg.mu.Lock()
+	cfg.cancelBuild = cancel
+	cfg.built = built
+	cfg.mu.Unlock()
+	return ctx, func() {
+		cfg.mu.Lock()
+		cfg.cancelBuild = nil
+		cfg.built = nil
+		cfg.mu.Unlock()
+		cancel()
+		close(built)
+	}
+}
+
+// stopBuild cancels the build, command or tests in progress and waits for them to return.
+// They run in their own process group, an interrupt of gommm does not reach them.
+func (cfg *root) stopBuild() {
+	cfg.mu.Lock()
+	cancel, built := cfg.cancelBuild, cfg.built
+	cfg.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-built
+}
+
+// cancel cancels the build, command or tests in progress, see watch
+func (cfg *root) cancel() {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if cfg.cancelBuild != nil {
+		cfg.cancelBuild()
+		cfg.cancelled = true
+	}
+}
+
+// superseded reports whether a newer batch cancelled the build of the current one
+func (cfg *root) superseded() bool {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.cancelled
+}
+
+// command runs the command of a run action with the environment of the env files,
+// it reports whether the command succeeded
+func (cfg *root) command(command string) bool {
+	cfg.logger.Printf("Running %s\n", command)
+	ctx, done := cfg.cancellable()
+	output, err := gommm.RunCommand(ctx, cfg.Path, cfg.environ, command)
+	done()
+	os.Stdout.Write(output)
+	if err == context.Canceled {
+		cfg.logger.Println("Command cancelled, sources changed")
+		return false
+	}
+	if err != nil {
+		cfg.logger.Printf("%sCommand failed%s: %s err:%v\n", cfg.colorRed, cfg.colorReset, command, err)
+		return false
+	}
+	return true
+}
+
+// restart stops the app, starting it again right away when start is set
+func (cfg *root) restart(logger *log.Logger, runner gommm.Runner, start bool) {
+	logger.Println("Restarting...")
+	var err error
+	if start {
+		_, err = runner.Replace()
+	} else {
+		err = runner.Kill()
+	}
+	if err != nil {
+		logger.Printf("%sRestart failed%s: %v\n", cfg.colorRed, cfg.colorReset, err)
+		return
+	}
+	logger.Printf("%sRestarted%s\n", cfg.colorGreen, cfg.colorReset)
+}
+
+// build builds and starts the app when start is set, logging to logger
+func (cfg *root) build(logger *log.Logger, builder gommm.Builder, runner gommm.Runner, start bool) {
+	logger.Println("Building...")
+	ctx, done := cfg.cancellable()
+	err := builder.Build(ctx)
+	done()
+	if err == context.Canceled {
+		logger.Println("Build cancelled, sources changed")
+		return
+	}
+	if err != nil {
+		logger.Printf("%sBuild failed%s\n", cfg.colorRed, cfg.colorReset)
+		if diags := builder.Diagnostics(); len(diags) > 0 {
+			for _, d := range diags {
+				fmt.Println(d)
 			}
-			if (allFiles || filepath.Ext(path) == ".go") && info.ModTime().After(cfg.startTime) {
-				cb(path)
-				cfg.startTime = time.Now()
-				return errors.New("done")
+		} else {
+			fmt.Println(builder.Errors())
+		}
+		if cfg.FailIfFirst && cfg.count == 0 {
+			os.Exit(1)
+		}
+	} else {
+		if start {
+			_, err = runner.Run()
+		}
+		if err != nil {
+			logger.Printf("%sBuild finished but the app did not start%s: %v\n", cfg.colorRed, cfg.colorReset, err)
+			if cfg.FailIfFirst && cfg.count == 0 {
+				os.Exit(1)
 			}
-			return nil
-		})
-		time.Sleep(500 * time.Millisecond)
+		} else {
+			logger.Printf("%sBuild finished%s\n", cfg.colorGreen, cfg.colorReset)
+		}
 	}
+	cfg.count++
+	time.Sleep(100 * time.Millisecond)
 }
 
-func shutdown(runner gommm.Runner) {
+// shutdown stops the build in progress and the runners on an interrupt, then exits
+func (cfg *root) shutdown(runners ...gommm.Runner) {
 	c := make(chan os.Signal, 2)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		s := <-c
 		log.Println("Got signal: ", s)
-		err := runner.Kill()
-		if err != nil {
-			log.Print("Error killing: ", err)
+		cfg.stopBuild()
+		for _, runner := range runners {
+			err := runner.Kill()
+			if err != nil {
+				log.Print("Error killing: ", err)
+			}
 		}
 		os.Exit(1)
 	}()
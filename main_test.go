@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_Test_Watcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module app\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "app_test.go"), []byte("package app\n"), 0644)
+
+	for _, all := range []bool{false, true} {
+		cfg := &root{Path: dir, All: all, Poll: true, logger: log.New(ioutil.Discard, "", 0)}
+		cmd := &test{rt: cfg, Packages: []string{"./..."}}
+		watcher := cfg.newWatcher(cmd.graphs()...)
+		watcher.Close()
+		// --all watches every file instead of the test graph
+		if all && len(cfg.graphs) != 0 || !all && len(cfg.graphs) != 1 {
+			t.Errorf("Expected the test graph only without --all, got %d graphs with --all=%v", len(cfg.graphs), all)
+		}
+	}
+}
+
+func Test_Batch_EnvChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gommm_main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	env := filepath.Join(dir, ".env")
+	ioutil.WriteFile(env, []byte("A=1\n"), 0644)
+
+	cfg := &root{Path: dir, All: true, Poll: true, EnvFile: []string{".env"}, logger: log.New(ioutil.Discard, "", 0)}
+	cfg.evalenv()
+	watcher := cfg.newWatcher()
+	defer watcher.Close()
+	reran, called := 0, 0
+	cfg.envChanged = func() { reran++ }
+	cb := func(paths []string) { called++ }
+
+	// the tests are rerun with the new environment, not only those of the other changes
+	ioutil.WriteFile(env, []byte("A=2\n"), 0644)
+	cfg.batch(watcher, []string{env, filepath.Join(dir, "a.go")}, cb)
+	if reran != 1 || called != 0 {
+		t.Errorf("Expected a rerun for the changed environment, got %d reruns and %d callbacks", reran, called)
+	}
+	cfg.batch(watcher, []string{env}, cb)
+	if reran != 1 || called != 0 {
+		t.Errorf("Expected nothing for an unchanged environment, got %d reruns and %d callbacks", reran, called)
+	}
+}